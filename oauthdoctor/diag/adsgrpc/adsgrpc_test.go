@@ -0,0 +1,159 @@
+// Copyright 2019 Google LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adsgrpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fakeHealthServer is a grpc_health_v1.HealthServer that always reports a
+// fixed status, so CheckHealth can be exercised without a real Google Ads
+// API endpoint.
+type fakeHealthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+	status grpc_health_v1.HealthCheckResponse_ServingStatus
+}
+
+func (f *fakeHealthServer) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	return &grpc_health_v1.HealthCheckResponse{Status: f.status}, nil
+}
+
+func dialFake(t *testing.T, lis net.Listener) *grpc.ClientConn {
+	t.Helper()
+	conn, err := Dial(context.Background(), lis.Addr().String(), insecure.NewCredentials())
+	if err != nil {
+		t.Fatalf("Dial() error: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestCheckHealth(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting fake gRPC listener: %s", err)
+	}
+
+	srv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(srv, &fakeHealthServer{status: grpc_health_v1.HealthCheckResponse_SERVING})
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn := dialFake(t, lis)
+	if err := CheckHealth(context.Background(), conn); err != nil {
+		t.Errorf("CheckHealth() error: %s", err)
+	}
+}
+
+func TestCheckHealthNotServing(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting fake gRPC listener: %s", err)
+	}
+
+	srv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(srv, &fakeHealthServer{status: grpc_health_v1.HealthCheckResponse_NOT_SERVING})
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn := dialFake(t, lis)
+	if err := CheckHealth(context.Background(), conn); err == nil || !strings.Contains(err.Error(), "NOT_SERVING") {
+		t.Errorf("CheckHealth() error: got %v, want a NOT_SERVING error", err)
+	}
+}
+
+// encodeResourceNames wire-encodes names as the repeated resource_names
+// field (field 1) of a ListAccessibleCustomersResponse.
+func encodeResourceNames(names []string) []byte {
+	var b []byte
+	for _, name := range names {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, name)
+	}
+	return b
+}
+
+// fakeCustomerService implements just enough of CustomerService to serve
+// ListAccessibleCustomers over the raw codec, the way the real Google Ads
+// API server would over the real one.
+func fakeCustomerService(gotMetadata *metadata.MD, resourceNames []string) *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: "google.ads.googleads." + APIVersion + ".services.CustomerService",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			{
+				MethodName: "ListAccessibleCustomers",
+				Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+					var req []byte
+					if err := dec(&req); err != nil {
+						return nil, err
+					}
+					if md, ok := metadata.FromIncomingContext(ctx); ok {
+						*gotMetadata = md
+					}
+					resp := encodeResourceNames(resourceNames)
+					return resp, nil
+				},
+			},
+		},
+	}
+}
+
+func TestListAccessibleCustomers(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting fake gRPC listener: %s", err)
+	}
+
+	var gotMetadata metadata.MD
+	srv := grpc.NewServer()
+	srv.RegisterService(fakeCustomerService(&gotMetadata, []string{"customers/111", "customers/222"}), nil)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn := dialFake(t, lis)
+	names, err := ListAccessibleCustomers(context.Background(), conn, "ACCESS_TOKEN", "GoodDevToken", "1234567890")
+	if err != nil {
+		t.Fatalf("ListAccessibleCustomers() error: %s", err)
+	}
+
+	want := []string{"customers/111", "customers/222"}
+	if len(names) != len(want) {
+		t.Fatalf("ListAccessibleCustomers() = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("ListAccessibleCustomers()[%d] = %q, want %q", i, names[i], want[i])
+		}
+	}
+
+	if got := gotMetadata.Get("authorization"); len(got) != 1 || got[0] != "Bearer ACCESS_TOKEN" {
+		t.Errorf("authorization metadata: got %v, want %q", got, "Bearer ACCESS_TOKEN")
+	}
+	if got := gotMetadata.Get("developer-token"); len(got) != 1 || got[0] != "GoodDevToken" {
+		t.Errorf("developer-token metadata: got %v, want %q", got, "GoodDevToken")
+	}
+	if got := gotMetadata.Get("login-customer-id"); len(got) != 1 || got[0] != "1234567890" {
+		t.Errorf("login-customer-id metadata: got %v, want %q", got, "1234567890")
+	}
+}
@@ -0,0 +1,737 @@
+// Copyright 2019 Google LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package diag reads, validates and repairs Google Ads API client library
+// OAuth2 configuration files.
+package diag
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/googleads/google-ads-doctor/oauthdoctor/diag/secrets"
+)
+
+// OAuthFlow identifies which of the two OAuth2 flows supported by the
+// Google Ads API a config file is set up for.
+type OAuthFlow string
+
+// The two OAuth2 flows the Google Ads API client libraries support.
+const (
+	InstalledApp   OAuthFlow = "InstalledApp"
+	ServiceAccount OAuthFlow = "ServiceAccount"
+)
+
+// The config values oauthdoctor knows how to read, validate and rewrite. They
+// are plain strings (not a distinct named type) so that they can be used
+// directly as ConfigKeys field names and as map keys.
+const (
+	DevToken         = "DevToken"
+	ClientID         = "ClientID"
+	ClientSecret     = "ClientSecret"
+	RefreshToken     = "RefreshToken"
+	LoginCustomerID  = "LoginCustomerID"
+	LinkedCustomerID = "LinkedCustomerID"
+	DelegatedAccount = "DelegatedAccount"
+	PrivateKeyPath   = "PrivateKeyPath"
+)
+
+// ConfigKeys holds the OAuth2 values read out of (or written back into) a
+// client library config file.
+type ConfigKeys struct {
+	ClientID         string
+	ClientSecret     string
+	DevToken         string
+	RefreshToken     string
+	LoginCustomerID  string
+	LinkedCustomerID string
+	DelegatedAccount string
+	PrivateKeyPath   string
+}
+
+// ServiceAccountInfo mirrors the fields of a GCP service account JSON key
+// file, as referenced by a config file's PrivateKeyPath.
+type ServiceAccountInfo struct {
+	Type                    string `json:"type"`
+	ProjectID               string `json:"project_id"`
+	PrivateKeyID            string `json:"private_key_id"`
+	PrivateKey              string `json:"private_key"`
+	ClientEmail             string `json:"client_email"`
+	ClientID                string `json:"client_id"`
+	AuthURI                 string `json:"auth_uri"`
+	TokenURI                string `json:"token_uri"`
+	AuthProviderX509CertURL string `json:"auth_provider_x509_cert_url"`
+	ClientX509CertURL       string `json:"client_x509_cert_url"`
+}
+
+// ConfigFile describes a single client library config file on disk, along
+// with the OAuth2 values that were (or will be) read from it.
+//
+// ConfigKeys and ServiceAccountInfo are kept as named (not embedded) fields
+// because they both declare a ClientID field; embedding either one would
+// make cfg.ClientID an ambiguous selector.
+type ConfigFile struct {
+	Filename           string
+	Filepath           string
+	Lang               string
+	OAuthType          OAuthFlow
+	ConfigKeys         ConfigKeys
+	ServiceAccountInfo ServiceAccountInfo
+
+	// Resolver resolves secrets.IsReference values (vault://, gsm://,
+	// age://) to their plaintext. A nil Resolver defaults to
+	// secrets.DefaultMultiplexer(), so existing callers that only ever deal
+	// in literal values don't need to set this.
+	Resolver secrets.Resolver
+
+	// Materialize must be set (typically from a --materialize CLI flag)
+	// before ReplaceConfig is allowed to overwrite a secret reference with
+	// a literal value, so a config file already wired to Vault/GSM/age
+	// doesn't silently fall back to storing a plaintext secret.
+	Materialize bool
+}
+
+// String implements fmt.Stringer so that %s formatting (used throughout the
+// test suite) keeps working now that ConfigFile carries non-string fields
+// (Materialize, Resolver) alongside its original all-string ones.
+func (c ConfigFile) String() string {
+	return fmt.Sprintf("{Filename:%s Filepath:%s Lang:%s OAuthType:%s ConfigKeys:%+v ServiceAccountInfo:%+v Materialize:%v}",
+		c.Filename, c.Filepath, c.Lang, c.OAuthType, c.ConfigKeys, c.ServiceAccountInfo, c.Materialize)
+}
+
+// resolver returns c.Resolver, defaulting to secrets.DefaultMultiplexer()
+// when unset.
+func (c *ConfigFile) resolver() secrets.Resolver {
+	if c.Resolver != nil {
+		return c.Resolver
+	}
+	return secrets.DefaultMultiplexer()
+}
+
+// resolvedField returns the value of the ConfigKeys field named by key,
+// resolving it first if it's a secret reference. The ConfigKeys field
+// itself is left untouched, so Print and ReplaceConfig keep seeing the
+// reference URI rather than the secret it points to.
+func (c *ConfigFile) resolvedField(ctx context.Context, key string) (string, error) {
+	val := c.field(key)
+	if !secrets.IsReference(val) {
+		return val, nil
+	}
+	return c.resolver().Resolve(ctx, val)
+}
+
+// defaultConfigFilenames maps a client library language to the filename it
+// expects to find its config under, relative to the user's home directory.
+var defaultConfigFilenames = map[string]string{
+	"python": "google-ads.yaml",
+	"ruby":   "google_ads_config.rb",
+	"dotnet": "App.Config",
+	"php":    "google_ads_php.ini",
+	"java":   "ads.properties",
+	"node":   "google-ads.env",
+	"perl":   "googleads.properties",
+}
+
+// GetConfigFile returns the ConfigFile that oauthdoctor should inspect for
+// the given client library language. If path is empty, the language's
+// default config location in the user's home directory is used; otherwise
+// path is split into its directory and filename.
+func GetConfigFile(lang, path string) ConfigFile {
+	if path == "" {
+		usr, err := user.Current()
+		if err != nil {
+			log.Printf("Error getting current user: %s", err)
+		}
+		return ConfigFile{
+			Filename: defaultConfigFilenames[lang],
+			Filepath: usr.HomeDir,
+			Lang:     lang,
+		}
+	}
+
+	return ConfigFile{
+		Filename: filepath.Base(path),
+		Filepath: filepath.Dir(path),
+		Lang:     lang,
+	}
+}
+
+// GetFilepath returns the full path to the config file.
+func (c *ConfigFile) GetFilepath() string {
+	return filepath.Join(c.Filepath, c.Filename)
+}
+
+// field returns the value of the ConfigKeys field named by key.
+func (c *ConfigFile) field(key string) string {
+	switch key {
+	case DevToken:
+		return c.ConfigKeys.DevToken
+	case ClientID:
+		return c.ConfigKeys.ClientID
+	case ClientSecret:
+		return c.ConfigKeys.ClientSecret
+	case RefreshToken:
+		return c.ConfigKeys.RefreshToken
+	case LoginCustomerID:
+		return c.ConfigKeys.LoginCustomerID
+	case LinkedCustomerID:
+		return c.ConfigKeys.LinkedCustomerID
+	case DelegatedAccount:
+		return c.ConfigKeys.DelegatedAccount
+	case PrivateKeyPath:
+		return c.ConfigKeys.PrivateKeyPath
+	}
+	return ""
+}
+
+// setField assigns val to the ConfigKeys field named by key.
+func (c *ConfigFile) setField(key string, val string) {
+	switch key {
+	case DevToken:
+		c.ConfigKeys.DevToken = val
+	case ClientID:
+		c.ConfigKeys.ClientID = val
+	case ClientSecret:
+		c.ConfigKeys.ClientSecret = val
+	case RefreshToken:
+		c.ConfigKeys.RefreshToken = val
+	case LoginCustomerID:
+		c.ConfigKeys.LoginCustomerID = val
+	case LinkedCustomerID:
+		c.ConfigKeys.LinkedCustomerID = val
+	case DelegatedAccount:
+		c.ConfigKeys.DelegatedAccount = val
+	case PrivateKeyPath:
+		c.ConfigKeys.PrivateKeyPath = val
+	}
+}
+
+// Regular expressions the known config values are expected to match. They
+// are intentionally loose: oauthdoctor's job is to catch obviously wrong
+// values (unset placeholders, copy/paste mistakes), not to re-implement
+// Google's own validation.
+var (
+	devTokenRegexp        = regexp.MustCompile(`^[a-zA-Z0-9_-]{15,30}$`)
+	clientIDRegexp        = regexp.MustCompile(`^[0-9]+-[a-zA-Z0-9]+\.apps\.googleusercontent\.com$`)
+	clientSecretRegexp    = regexp.MustCompile(`^[a-zA-Z0-9_-]{10,40}$`)
+	refreshTokenRegexp    = regexp.MustCompile(`^[a-zA-Z0-9_./-]+$`)
+	loginCustomerIDRegexp = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// isPlaceholder reports whether val still looks like one of the
+// "INSERT_..._HERE" placeholders that ship in the client libraries' example
+// config files.
+func isPlaceholder(val string) bool {
+	return strings.Contains(val, "INSERT") || strings.HasSuffix(val, "_HERE")
+}
+
+// Validate checks the config values that were read from the file and
+// reports whether they look usable. Literal values are checked purely
+// structurally, with no network calls; a value that's a secrets.Reference
+// (vault://, gsm://, age://) is resolved to its plaintext first, so
+// resolving it is the only network call Validate can make.
+func (c *ConfigFile) Validate() (bool, error) {
+	ctx := context.Background()
+	var errs []string
+
+	resolved := make(map[string]string)
+	for _, key := range []string{DevToken, ClientID, ClientSecret, RefreshToken, LoginCustomerID, DelegatedAccount, PrivateKeyPath} {
+		val, err := c.resolvedField(ctx, key)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", key, err))
+			continue
+		}
+		resolved[key] = val
+	}
+
+	formatChecks := []struct {
+		key string
+		re  *regexp.Regexp
+	}{
+		{DevToken, devTokenRegexp},
+		{ClientID, clientIDRegexp},
+		{ClientSecret, clientSecretRegexp},
+		{RefreshToken, refreshTokenRegexp},
+		{LoginCustomerID, loginCustomerIDRegexp},
+	}
+
+	for _, chk := range formatChecks {
+		val := resolved[chk.key]
+		if val == "" {
+			continue
+		}
+		switch {
+		case isPlaceholder(val):
+			errs = append(errs, fmt.Sprintf("%s still has the placeholder value %q from the example config file", chk.key, val))
+		case !chk.re.MatchString(val):
+			errs = append(errs, fmt.Sprintf("%s %q is not in the expected format", chk.key, val))
+		}
+	}
+
+	var required []string
+	if c.OAuthType == ServiceAccount {
+		required = []string{DevToken, PrivateKeyPath, DelegatedAccount}
+	} else {
+		required = []string{DevToken, ClientID, ClientSecret, RefreshToken}
+	}
+
+	for _, key := range required {
+		val, ok := resolved[key]
+		if ok && val == "" {
+			errs = append(errs, fmt.Sprintf("%s is required but missing from the config file", key))
+		}
+		// If !ok, resolving the reference already failed above; that error
+		// covers this key too.
+	}
+
+	// google-ads-node requires a LinkedCustomerID alongside LoginCustomerID
+	// when the manager account in LoginCustomerID is impersonating a client
+	// account it isn't a direct parent of.
+	if c.Lang == "node" && c.ConfigKeys.LoginCustomerID != "" && c.ConfigKeys.LinkedCustomerID == "" {
+		errs = append(errs, "LinkedCustomerID is required but missing from the config file when LoginCustomerID is set")
+	}
+
+	if len(errs) > 0 {
+		return false, errors.New(strings.Join(errs, "; "))
+	}
+	return true, nil
+}
+
+// hide returns "******" in place of val when hidePII is set and val is
+// non-empty, so that Print never echoes sensitive values unless asked to. A
+// secret reference (vault://, gsm://, age://) is never hidden: it's a
+// pointer to where the secret lives, not the secret itself, and seeing it
+// is the whole point of Print(hidePII=true) for a reference-backed value.
+func hide(val string, hidePII bool) string {
+	if secrets.IsReference(val) {
+		return val
+	}
+	if hidePII && val != "" {
+		return "******"
+	}
+	return val
+}
+
+// Print logs the contents of the config file. Sensitive values (tokens,
+// secrets, private keys) are replaced with "******" when hidePII is true;
+// everything else is always shown so the log remains useful for debugging.
+func (c *ConfigFile) Print(hidePII bool) {
+	log.Printf("Config file: %s", c.GetFilepath())
+	log.Printf("Language: %s", c.Lang)
+	log.Printf("OAuth type: %s", c.OAuthType)
+
+	if c.OAuthType == ServiceAccount {
+		log.Printf("Project ID: %s", c.ServiceAccountInfo.ProjectID)
+		log.Printf("Client email: %s", c.ServiceAccountInfo.ClientEmail)
+		log.Printf("Private key ID: %s", hide(c.ServiceAccountInfo.PrivateKeyID, hidePII))
+		log.Printf("Private key: %s", hide(c.ServiceAccountInfo.PrivateKey, hidePII))
+		log.Printf("Delegated account: %s", c.ConfigKeys.DelegatedAccount)
+		return
+	}
+
+	log.Printf("Developer token: %s", hide(c.ConfigKeys.DevToken, hidePII))
+	log.Printf("Client ID: %s", hide(c.ConfigKeys.ClientID, hidePII))
+	log.Printf("Client secret: %s", hide(c.ConfigKeys.ClientSecret, hidePII))
+	log.Printf("Refresh token: %s", hide(c.ConfigKeys.RefreshToken, hidePII))
+	log.Printf("Login customer ID: %s", c.ConfigKeys.LoginCustomerID)
+}
+
+// splitKeyVal splits line on sep into a key and a value, and looks the key
+// up in keys. It returns ok=false if line isn't a recognized "key<sep>value"
+// assignment.
+func splitKeyVal(line, sep string, keys map[string]string) (string, string, bool) {
+	parts := strings.SplitN(line, sep, 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	key, ok := keys[strings.TrimSpace(parts[0])]
+	if !ok {
+		return "", "", false
+	}
+
+	return key, strings.Trim(strings.TrimSpace(parts[1]), `"'`), true
+}
+
+var pythonKeys = map[string]string{
+	"developer_token":    DevToken,
+	"client_id":          ClientID,
+	"client_secret":      ClientSecret,
+	"refresh_token":      RefreshToken,
+	"login_customer_id":  LoginCustomerID,
+	"impersonated_email": DelegatedAccount,
+}
+
+var javaKeys = map[string]string{
+	"api.googleads.developerToken":  DevToken,
+	"api.googleads.clientId":        ClientID,
+	"api.googleads.clientSecret":    ClientSecret,
+	"api.googleads.refreshToken":    RefreshToken,
+	"api.googleads.loginCustomerId": LoginCustomerID,
+}
+
+var phpKeys = map[string]string{
+	"developerToken":  DevToken,
+	"clientId":        ClientID,
+	"clientSecret":    ClientSecret,
+	"refreshToken":    RefreshToken,
+	"loginCustomerId": LoginCustomerID,
+}
+
+var rubyKeys = map[string]string{
+	"client_id":         ClientID,
+	"client_secret":     ClientSecret,
+	"developer_token":   DevToken,
+	"refresh_token":     RefreshToken,
+	"login_customer_id": LoginCustomerID,
+}
+
+// nodeKeys maps the dotenv variable names google-ads-node reads out of
+// ~/google-ads.env to the ConfigKeys field they fill in.
+var nodeKeys = map[string]string{
+	"GOOGLE_ADS_CLIENT_ID":          ClientID,
+	"GOOGLE_ADS_CLIENT_SECRET":      ClientSecret,
+	"GOOGLE_ADS_DEVELOPER_TOKEN":    DevToken,
+	"GOOGLE_ADS_REFRESH_TOKEN":      RefreshToken,
+	"GOOGLE_ADS_LOGIN_CUSTOMER_ID":  LoginCustomerID,
+	"GOOGLE_ADS_LINKED_CUSTOMER_ID": LinkedCustomerID,
+	"GOOGLE_ADS_IMPERSONATED_EMAIL": DelegatedAccount,
+}
+
+// rubySettingRegexp matches a single `c.key = 'value'` assignment inside a
+// google-ads-ruby `Config.new do |c| ... end` block.
+var rubySettingRegexp = regexp.MustCompile(`^c\.(\w+)\s*=\s*['"]([^'"]*)['"]`)
+
+func parseRubyLine(line string) (string, string, bool) {
+	m := rubySettingRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return "", "", false
+	}
+	key, ok := rubyKeys[m[1]]
+	if !ok {
+		return "", "", false
+	}
+	return key, m[2], true
+}
+
+// parseConfigLine extracts a (key, value) pair from a single line of a
+// client library config file, using the grammar for lang. It returns
+// ok=false for blank lines, comments, and lines oauthdoctor doesn't
+// recognize.
+func parseConfigLine(lang, line string) (string, string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", "", false
+	}
+
+	switch lang {
+	case "python":
+		if strings.HasPrefix(line, "#") {
+			return "", "", false
+		}
+		return splitKeyVal(line, ":", pythonKeys)
+	case "ruby":
+		return parseRubyLine(line)
+	case "php":
+		if strings.HasPrefix(line, ";") {
+			return "", "", false
+		}
+		return splitKeyVal(line, "=", phpKeys)
+	case "java":
+		if strings.HasPrefix(line, "#") {
+			return "", "", false
+		}
+		return splitKeyVal(line, "=", javaKeys)
+	case "node":
+		if strings.HasPrefix(line, "#") {
+			return "", "", false
+		}
+		return splitKeyVal(line, "=", nodeKeys)
+	case "perl":
+		// Google::Ads::GoogleAds::Client reads googleads.properties with the
+		// same dotted-key grammar as the Java client library, but also
+		// tolerates "!" as a comment prefix in addition to "#".
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			return "", "", false
+		}
+		return splitKeyVal(line, "=", javaKeys)
+	}
+	return "", "", false
+}
+
+// ParseKeyValueFile reads a python, ruby, php, java, node or perl client
+// library config file and returns the ConfigFile it describes.
+func ParseKeyValueFile(lang, path string, oauthType OAuthFlow) (ConfigFile, error) {
+	cfg := ConfigFile{
+		Filepath:  filepath.Dir(path),
+		Filename:  filepath.Base(path),
+		Lang:      lang,
+		OAuthType: oauthType,
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, fmt.Errorf("error opening config file %s: %s", path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if key, val, ok := parseConfigLine(lang, scanner.Text()); ok {
+			cfg.setField(key, val)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return cfg, fmt.Errorf("error reading config file %s: %s", path, err)
+	}
+
+	return cfg, nil
+}
+
+// netConfigXML is the subset of a .NET App.Config file oauthdoctor cares
+// about: a flat list of <add key="..." value="..."/> settings.
+type netConfigXML struct {
+	XMLName     xml.Name `xml:"configuration"`
+	AppSettings struct {
+		Add []struct {
+			Key   string `xml:"key,attr"`
+			Value string `xml:"value,attr"`
+		} `xml:"add"`
+	} `xml:"appSettings"`
+}
+
+var dotnetKeys = map[string]string{
+	"DeveloperToken":          DevToken,
+	"OAuth2ClientId":          ClientID,
+	"OAuth2ClientSecret":      ClientSecret,
+	"OAuth2RefreshToken":      RefreshToken,
+	"OAuth2SecretsJsonPath":   PrivateKeyPath,
+	"OAuth2ImpersonatedEmail": DelegatedAccount,
+	"LoginCustomerId":         LoginCustomerID,
+}
+
+// ParseXMLFile reads a .NET client library App.Config file and returns the
+// ConfigFile it describes.
+func ParseXMLFile(path string, oauthType OAuthFlow) (ConfigFile, error) {
+	cfg := ConfigFile{
+		Filepath:  filepath.Dir(path),
+		Filename:  filepath.Base(path),
+		Lang:      "dotnet",
+		OAuthType: oauthType,
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("error reading config file %s: %s", path, err)
+	}
+
+	var parsed netConfigXML
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		return cfg, err
+	}
+
+	for _, add := range parsed.AppSettings.Add {
+		if key, ok := dotnetKeys[add.Key]; ok {
+			cfg.setField(key, add.Value)
+		}
+	}
+
+	return cfg, nil
+}
+
+// parseServiceAccJSON reads the GCP service account key file at
+// c.ConfigKeys.PrivateKeyPath and populates c.ServiceAccountInfo from it.
+func (c *ConfigFile) parseServiceAccJSON() error {
+	data, err := ioutil.ReadFile(c.ConfigKeys.PrivateKeyPath)
+	if err != nil {
+		return fmt.Errorf("error reading service account file %s: %s", c.ConfigKeys.PrivateKeyPath, err)
+	}
+
+	var info ServiceAccountInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return fmt.Errorf("error parsing service account file %s: %s", c.ConfigKeys.PrivateKeyPath, err)
+	}
+
+	c.ServiceAccountInfo = info
+	return nil
+}
+
+// configLineTemplates gives, for each language, the fmt template used to
+// append a freshly-set value to the end of a config file: the first %s is
+// the language-specific setting name, the second is the new value.
+var configLineTemplates = map[string]map[string]string{
+	"python": {
+		DevToken:     "\ndeveloper_token:%s",
+		ClientID:     "\nclient_id:%s",
+		ClientSecret: "\nclient_secret:%s",
+		RefreshToken: "\nrefresh_token:%s",
+	},
+	"ruby": {
+		DevToken:     "\nc.developer_token= \"%s\"",
+		ClientID:     "\nc.client_id= \"%s\"",
+		ClientSecret: "\nc.client_secret= \"%s\"",
+		RefreshToken: "\nc.refresh_token= \"%s\"",
+	},
+	"php": {
+		DevToken:     "\ndeveloperToken= \"%s\"",
+		ClientID:     "\nclientId= \"%s\"",
+		ClientSecret: "\nclientSecret= \"%s\"",
+		RefreshToken: "\nrefreshToken= \"%s\"",
+	},
+	"java": {
+		DevToken:     "\napi.googleads.developerToken=%s",
+		ClientID:     "\napi.googleads.clientId=%s",
+		ClientSecret: "\napi.googleads.clientSecret=%s",
+		RefreshToken: "\napi.googleads.refreshToken=%s",
+	},
+	"dotnet": {
+		DevToken:     "\n<add key=\"DeveloperToken\" value=\"%s\"/>",
+		ClientID:     "\n<add key=\"OAuth2ClientId\" value=\"%s\"/>",
+		ClientSecret: "\n<add key=\"OAuth2ClientSecret\" value=\"%s\"/>",
+		RefreshToken: "\n<add key=\"OAuth2RefreshToken\" value=\"%s\"/>",
+	},
+	"node": {
+		DevToken:         "\nGOOGLE_ADS_DEVELOPER_TOKEN=%s",
+		ClientID:         "\nGOOGLE_ADS_CLIENT_ID=%s",
+		ClientSecret:     "\nGOOGLE_ADS_CLIENT_SECRET=%s",
+		RefreshToken:     "\nGOOGLE_ADS_REFRESH_TOKEN=%s",
+		LinkedCustomerID: "\nGOOGLE_ADS_LINKED_CUSTOMER_ID=%s",
+	},
+	"perl": {
+		DevToken:     "\napi.googleads.developerToken=%s",
+		ClientID:     "\napi.googleads.clientId=%s",
+		ClientSecret: "\napi.googleads.clientSecret=%s",
+		RefreshToken: "\napi.googleads.refreshToken=%s",
+	},
+}
+
+// ReplaceConfigFromReader reads the current config file contents from r and
+// returns them with a new, active assignment of key to val appended. It
+// does not touch any existing line for key, commented out or not, so a
+// human reviewing the diff can see exactly what oauthdoctor changed.
+func (c *ConfigFile) ReplaceConfigFromReader(key string, val string, r io.Reader) string {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		log.Printf("Error reading config file %s: %s", c.GetFilepath(), err)
+		return ""
+	}
+
+	tmpl, ok := configLineTemplates[c.Lang][key]
+	if !ok {
+		log.Printf("Don't know how to set %s for %s config files", key, c.Lang)
+		return string(data)
+	}
+
+	return string(data) + fmt.Sprintf(tmpl, val)
+}
+
+// ReplaceConfig rewrites the config file on disk, appending a new active
+// assignment of key to val. The previous contents of the file are preserved
+// in a timestamped backup file next to it, whose path is returned.
+//
+// If key currently holds a secrets.Reference, ReplaceConfig refuses to
+// overwrite it with a literal val unless c.Materialize is set (typically
+// from a --materialize CLI flag): otherwise a config file already wired to
+// Vault/GSM/age would silently start storing the plaintext secret instead.
+func (c *ConfigFile) ReplaceConfig(key string, val string) string {
+	if secrets.IsReference(c.field(key)) && !secrets.IsReference(val) && !c.Materialize {
+		log.Printf("%s is a secret reference (%s); refusing to overwrite it with a literal value without --materialize", key, c.field(key))
+		return ""
+	}
+
+	original := c.GetFilepath()
+
+	f, err := os.Open(original)
+	if err != nil {
+		log.Printf("Error opening config file %s: %s", original, err)
+		return ""
+	}
+	newContent := c.ReplaceConfigFromReader(key, val, f)
+	f.Close()
+
+	backup := fmt.Sprintf("%s_%s", original, time.Now().Format("2006-01-02_15-04-05"))
+	if err := os.Rename(original, backup); err != nil {
+		log.Printf("Error backing up config file %s: %s", original, err)
+		return ""
+	}
+
+	if err := ioutil.WriteFile(original, []byte(newContent), 0644); err != nil {
+		log.Printf("Error writing new config file %s: %s", original, err)
+		return ""
+	}
+
+	log.Printf("Backed up the original config file to %s", backup)
+	return backup
+}
+
+// minGoMajor and minGoMinor are the oldest Go release oauthdoctor is
+// expected to run on. This tracks the floor google.golang.org/grpc itself
+// requires, since that's what actually fails to build below it.
+const (
+	minGoMajor = 1
+	minGoMinor = 19
+)
+
+// leadingDigits returns the longest prefix of s consisting of digits.
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// checkGoVersion reports an error if version (as reported by `go version`,
+// e.g. "go1.13.1" or "go1.13rc1") is older than the minimum Go release
+// oauthdoctor supports.
+func checkGoVersion(version string) error {
+	v := strings.TrimPrefix(version, "go")
+
+	parts := strings.SplitN(v, ".", 3)
+	if len(parts) < 2 {
+		return fmt.Errorf("go version string %q is too short to parse", version)
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("could not parse go version %q: %s", version, err)
+	}
+
+	minorDigits := leadingDigits(parts[1])
+	if minorDigits == "" {
+		return fmt.Errorf("could not parse go version %q", version)
+	}
+	minor, err := strconv.Atoi(minorDigits)
+	if err != nil {
+		return fmt.Errorf("could not parse go version %q: %s", version, err)
+	}
+
+	if major < minGoMajor || (major == minGoMajor && minor < minGoMinor) {
+		return fmt.Errorf("go version %q does not meet the minimum required version go%d.%d", version, minGoMajor, minGoMinor)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+// Copyright 2019 Google LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diag
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetConfigFileNodePerl(t *testing.T) {
+	usr, err := user.Current()
+	if err != nil {
+		t.Errorf("Error getting current user: %s\n", err)
+	}
+
+	tests := []struct {
+		desc string
+		lang string
+		want ConfigFile
+	}{
+		{
+			desc: "(Node.js) Get default config file",
+			lang: "node",
+			want: ConfigFile{
+				Filename: "google-ads.env",
+				Filepath: usr.HomeDir,
+				Lang:     "node",
+			},
+		},
+		{
+			desc: "(Perl) Get default config file",
+			lang: "perl",
+			want: ConfigFile{
+				Filename: "googleads.properties",
+				Filepath: usr.HomeDir,
+				Lang:     "perl",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got := GetConfigFile(test.lang, "")
+
+		if got != test.want {
+			t.Errorf("%s\ngot: %s\nwant: %s", test.desc, got, test.want)
+		}
+	}
+}
+
+func TestParseKeyValueFileNodePerl(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Errorf("Error getting current dir: %s", err)
+	}
+
+	tests := []struct {
+		desc       string
+		configPath string
+		lang       string
+		want       ConfigFile
+	}{
+		{
+			desc:       "(Node.js) Everything parses correctly",
+			configPath: filepath.Join(dir, "testdata", "node_config"),
+			lang:       "node",
+			want: ConfigFile{
+				Filepath:  filepath.Join(dir, "testdata"),
+				Filename:  "node_config",
+				Lang:      "node",
+				OAuthType: InstalledApp,
+				ConfigKeys: ConfigKeys{
+					ClientID:     "GoodClientID",
+					ClientSecret: "GoodClientSecret",
+					DevToken:     "GoodDevToken",
+					RefreshToken: "GoodRefreshToken",
+				},
+			},
+		},
+		{
+			desc:       "(Perl) Tolerates ! comments",
+			configPath: filepath.Join(dir, "testdata", "perl_config"),
+			lang:       "perl",
+			want: ConfigFile{
+				Filepath:  filepath.Join(dir, "testdata"),
+				Filename:  "perl_config",
+				Lang:      "perl",
+				OAuthType: InstalledApp,
+				ConfigKeys: ConfigKeys{
+					ClientID:     "GoodClientID",
+					ClientSecret: "GoodClientSecret",
+					DevToken:     "GoodDevToken",
+					RefreshToken: "GoodRefreshToken",
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := ParseKeyValueFile(test.lang, test.configPath, InstalledApp)
+		if err != nil {
+			t.Fatalf("%s\nParseKeyValueFile() error: %s", test.desc, err)
+		}
+
+		if got != test.want {
+			t.Errorf("%s\ngot: %s\nwant: %s", test.desc, got, test.want)
+		}
+	}
+}
+
+func TestReplaceConfigFromReaderNodePerl(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Errorf("Error getting current dir: %s", err)
+	}
+
+	tests := []struct {
+		desc      string
+		key       string
+		val       string
+		cfg       ConfigFile
+		commented string
+		added     string
+	}{
+		{
+			desc: "(Node.js) Replace refresh token correctly",
+			key:  RefreshToken,
+			val:  "new_refresh_token",
+			cfg: ConfigFile{
+				Lang:     "node",
+				Filepath: filepath.Join(dir, "testdata"),
+				Filename: "node_config",
+			},
+			commented: "# GOOGLE_ADS_DEVELOPER_TOKEN=",
+			added:     "\nGOOGLE_ADS_REFRESH_TOKEN=new_refresh_token",
+		},
+		{
+			desc: "(Perl) Replace refresh token correctly",
+			key:  RefreshToken,
+			val:  "new_refresh_token",
+			cfg: ConfigFile{
+				Lang:     "perl",
+				Filepath: filepath.Join(dir, "testdata"),
+				Filename: "perl_config",
+			},
+			commented: "!api.googleads.refreshToken=",
+			added:     "\napi.googleads.refreshToken=new_refresh_token",
+		},
+	}
+
+	for _, test := range tests {
+		f, err := os.Open(test.cfg.GetFilepath())
+		if err != nil {
+			t.Fatalf("ERROR: Problem opening config file: %s", err)
+		}
+		defer f.Close()
+
+		got := test.cfg.ReplaceConfigFromReader(test.key, test.val, f)
+
+		if !strings.Contains(got, test.commented) {
+			t.Errorf("%s\ngot: %s\nMissing commented: %s", test.desc, got, test.commented)
+		}
+
+		if !strings.Contains(got, test.added) {
+			t.Errorf("%s\ngot: %s\nMissing added: %s", test.desc, got, test.added)
+		}
+	}
+}
+
+func TestValidateNodeLinkedCustomerID(t *testing.T) {
+	tests := []struct {
+		desc    string
+		cfg     ConfigFile
+		wantErr string
+	}{
+		{
+			desc: "(Node.js) LinkedCustomerID required when LoginCustomerID is set",
+			cfg: ConfigFile{
+				Lang: "node",
+				ConfigKeys: ConfigKeys{
+					DevToken:        "GoodDevToken1234",
+					ClientID:        "0123456789-GoodClientID.apps.googleusercontent.com",
+					ClientSecret:    "GoodClientSecret",
+					RefreshToken:    "1/PG1Ap6P-Good_Refresh_Token",
+					LoginCustomerID: "1234567890",
+				},
+			},
+			wantErr: "LinkedCustomerID is required",
+		},
+		{
+			desc: "(Node.js) LinkedCustomerID present alongside LoginCustomerID",
+			cfg: ConfigFile{
+				Lang: "node",
+				ConfigKeys: ConfigKeys{
+					DevToken:         "GoodDevToken1234",
+					ClientID:         "0123456789-GoodClientID.apps.googleusercontent.com",
+					ClientSecret:     "GoodClientSecret",
+					RefreshToken:     "1/PG1Ap6P-Good_Refresh_Token",
+					LoginCustomerID:  "1234567890",
+					LinkedCustomerID: "1234567890",
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		ok, err := test.cfg.Validate()
+
+		if test.wantErr == "" {
+			if !ok || err != nil {
+				t.Errorf("%s\nValidate() = %v, %v; want ok, no error", test.desc, ok, err)
+			}
+			continue
+		}
+
+		if ok || err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s\nValidate() = %v, %v; want error containing %q", test.desc, ok, err, test.wantErr)
+		}
+	}
+}
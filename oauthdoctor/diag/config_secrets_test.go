@@ -0,0 +1,178 @@
+// Copyright 2019 Google LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diag
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeResolver is a secrets.Resolver that returns canned values, so these
+// tests don't need a live Vault/GSM/age backend.
+type fakeResolver map[string]string
+
+func (f fakeResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	val, ok := f[ref]
+	if !ok {
+		return "", fmt.Errorf("fakeResolver: no value registered for %q", ref)
+	}
+	return val, nil
+}
+
+func TestValidateWithSecretReferences(t *testing.T) {
+	const goodClientID = "012345678-8hafs7yfas0f0fh.apps.googleusercontent.com"
+
+	tests := []struct {
+		desc   string
+		cfg    ConfigFile
+		want   bool
+		errstr string
+	}{
+		{
+			desc: "Resolves a reference and passes format checks",
+			cfg: ConfigFile{
+				OAuthType: InstalledApp,
+				ConfigKeys: ConfigKeys{
+					DevToken:     "GoodDevToken1234",
+					ClientID:     goodClientID,
+					ClientSecret: "vault://secret/data/google-ads#client_secret",
+					RefreshToken: "GoodRefreshToken",
+				},
+				Resolver: fakeResolver{
+					"vault://secret/data/google-ads#client_secret": "09aufj0aj0ufa8s",
+				},
+			},
+			want: true,
+		},
+		{
+			desc: "Reference resolves to a malformed value",
+			cfg: ConfigFile{
+				OAuthType: InstalledApp,
+				ConfigKeys: ConfigKeys{
+					DevToken:     "GoodDevToken1234",
+					ClientID:     goodClientID,
+					ClientSecret: "vault://secret/data/google-ads#client_secret",
+					RefreshToken: "GoodRefreshToken",
+				},
+				Resolver: fakeResolver{
+					"vault://secret/data/google-ads#client_secret": "",
+				},
+			},
+			want:   false,
+			errstr: "ClientSecret is required but missing",
+		},
+		{
+			desc: "Resolver fails outright",
+			cfg: ConfigFile{
+				OAuthType: InstalledApp,
+				ConfigKeys: ConfigKeys{
+					DevToken:     "GoodDevToken1234",
+					ClientID:     goodClientID,
+					ClientSecret: "vault://secret/data/google-ads#client_secret",
+					RefreshToken: "GoodRefreshToken",
+				},
+				Resolver: fakeResolver{},
+			},
+			want:   false,
+			errstr: "no value registered",
+		},
+		{
+			desc: "No Resolver set and no references used: unaffected",
+			cfg: ConfigFile{
+				OAuthType: InstalledApp,
+				ConfigKeys: ConfigKeys{
+					DevToken:     "GoodDevToken1234",
+					ClientID:     goodClientID,
+					ClientSecret: "09aufj0aj0ufa8s",
+					RefreshToken: "GoodRefreshToken",
+				},
+			},
+			want: true,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := test.cfg.Validate()
+
+		if got != test.want {
+			t.Errorf("%s\nValidate() = %v, %v; want %v", test.desc, got, err, test.want)
+		}
+		if test.errstr != "" && (err == nil || !strings.Contains(err.Error(), test.errstr)) {
+			t.Errorf("%s\nValidate() error: got %v, want substring %q", test.desc, err, test.errstr)
+		}
+	}
+}
+
+func TestPrintSecretReference(t *testing.T) {
+	cfg := ConfigFile{
+		ConfigKeys: ConfigKeys{
+			ClientSecret: "vault://secret/data/google-ads#client_secret",
+		},
+	}
+
+	output := new(bytes.Buffer)
+	log.SetOutput(output)
+	cfg.Print(true)
+
+	if !strings.Contains(output.String(), "vault://secret/data/google-ads#client_secret") {
+		t.Errorf("Print(true) output does not contain the reference URI:\n%s", output)
+	}
+	if strings.Contains(output.String(), "******") {
+		t.Errorf("Print(true) hid a reference behind ******:\n%s", output)
+	}
+}
+
+func TestReplaceConfigRefusesToOverwriteReferenceWithoutMaterialize(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Error getting current dir: %s", err)
+	}
+
+	cfg := ConfigFile{
+		Lang:     "python",
+		Filepath: filepath.Join(dir, "testdata"),
+		Filename: "python_config2",
+		ConfigKeys: ConfigKeys{
+			RefreshToken: "vault://secret/data/google-ads#refresh_token",
+		},
+	}
+
+	if backup := cfg.ReplaceConfig(RefreshToken, "literalToken"); backup != "" {
+		os.Remove(cfg.GetFilepath())
+		os.Rename(backup, cfg.GetFilepath())
+		t.Fatalf("ReplaceConfig() = %q, want \"\" (refused) without Materialize set", backup)
+	}
+
+	cfg.Materialize = true
+	now := time.Now().Format("2006-01-02_")
+	backup := cfg.ReplaceConfig(RefreshToken, "literalToken")
+	config := cfg.GetFilepath()
+	defer func() {
+		os.Remove(config)
+		if err := os.Rename(backup, config); err != nil {
+			t.Errorf("Error restoring config file from %s to %s: %s", backup, config, err)
+		}
+	}()
+
+	if !strings.Contains(backup, "diag/testdata/python_config2_"+now) {
+		t.Errorf("ReplaceConfig() with Materialize=true = %q, want a backup path containing %q", backup, "diag/testdata/python_config2_"+now)
+	}
+}
@@ -651,8 +651,8 @@ func TestCheckGoVersion(t *testing.T) {
 		want    error
 	}{
 		{
-			desc:    "Version go1.11 is supported",
-			version: "go1.11",
+			desc:    "Version go1.19 is supported",
+			version: "go1.19",
 			want:    nil,
 		},
 		{
@@ -661,18 +661,18 @@ func TestCheckGoVersion(t *testing.T) {
 			want:    nil,
 		},
 		{
-			desc:    "Version go1.12.9 is supported",
-			version: "go1.12.9",
+			desc:    "Version go1.20.9 is supported",
+			version: "go1.20.9",
 			want:    nil,
 		},
 		{
-			desc:    "Version go1.13rc1 is supported",
-			version: "go1.13rc1",
+			desc:    "Version go1.21rc1 is supported",
+			version: "go1.21rc1",
 			want:    nil,
 		},
 		{
-			desc:    "Version 1.12 is supported",
-			version: "1.12",
+			desc:    "Version 1.20 is supported",
+			version: "1.20",
 			want:    nil,
 		},
 		{
@@ -680,6 +680,11 @@ func TestCheckGoVersion(t *testing.T) {
 			version: "go1.9",
 			want:    fmt.Errorf("minimum required"),
 		},
+		{
+			desc:    "Version go1.18 is not supported",
+			version: "go1.18",
+			want:    fmt.Errorf("minimum required"),
+		},
 		{
 			desc:    "Version go#&^% is not supported",
 			version: "go#&^%",
@@ -0,0 +1,192 @@
+// Copyright 2019 Google LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diag
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// installedAppAuthEndpoint is Google's OAuth2 consent screen.
+const installedAppAuthEndpoint = "https://accounts.google.com/o/oauth2/v2/auth"
+
+// notifyConsentURL is called with the URL the user needs to open in a
+// browser to authorize oauthdoctor. It is a var, rather than a direct
+// log.Printf call, so tests can intercept it instead of scraping log
+// output.
+var notifyConsentURL = func(consentURL string) {
+	log.Printf("Open the following URL in a browser to authorize oauthdoctor:\n\n%s\n", consentURL)
+}
+
+// callbackResult is what the loopback callback handler in
+// AuthorizeInstalledApp hands back once the browser redirects to it.
+type callbackResult struct {
+	code string
+	err  error
+}
+
+// AuthorizeInstalledApp runs the installed-app OAuth2 loopback flow to mint
+// a new refresh token: it starts a local HTTP server, prints a consent URL
+// for the user to open, waits for Google to redirect back to it with an
+// authorization code, exchanges that code for a refresh token, and writes
+// the refresh token into the config file via ReplaceConfig (which keeps a
+// timestamped backup of the previous contents). It returns the path to that
+// backup.
+func (c *ConfigFile) AuthorizeInstalledApp(ctx context.Context) (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("starting loopback listener: %s", err)
+	}
+
+	state, err := randomState()
+	if err != nil {
+		listener.Close()
+		return "", fmt.Errorf("generating OAuth2 state: %s", err)
+	}
+
+	redirectURI := fmt.Sprintf("http://%s/callback", listener.Addr().String())
+	resultCh := make(chan callbackResult, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		if reason := r.URL.Query().Get("error"); reason != "" {
+			http.Error(w, "Authorization failed, you may close this tab.", http.StatusBadRequest)
+			resultCh <- callbackResult{err: fmt.Errorf("authorization denied: %s", reason)}
+			return
+		}
+		if got := r.URL.Query().Get("state"); got != state {
+			http.Error(w, "State mismatch, you may close this tab.", http.StatusBadRequest)
+			resultCh <- callbackResult{err: fmt.Errorf("OAuth2 state mismatch: got %q, want %q", got, state)}
+			return
+		}
+		fmt.Fprintln(w, "Authorization complete. You may close this tab and return to oauthdoctor.")
+		resultCh <- callbackResult{code: r.URL.Query().Get("code")}
+	})
+
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	clientID, err := c.resolvedField(ctx, ClientID)
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %s", ClientID, err)
+	}
+	notifyConsentURL(buildConsentURL(clientID, redirectURI, state))
+
+	var res callbackResult
+	select {
+	case res = <-resultCh:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if res.err != nil {
+		return "", res.err
+	}
+
+	tok, err := c.exchangeAuthorizationCode(ctx, res.code, redirectURI)
+	if err != nil {
+		return "", fmt.Errorf("exchanging authorization code: %s", err)
+	}
+	if tok.RefreshToken == "" {
+		return "", errors.New("token endpoint did not return a refresh token; retry with prompt=consent")
+	}
+
+	backup := c.ReplaceConfig(RefreshToken, tok.RefreshToken)
+	if backup == "" {
+		return "", errors.New("failed to write the new refresh token to the config file")
+	}
+
+	return backup, nil
+}
+
+// buildConsentURL builds the URL the user opens to grant oauthdoctor
+// offline access to the Google Ads API.
+func buildConsentURL(clientID, redirectURI, state string) string {
+	v := url.Values{
+		"client_id":     {clientID},
+		"redirect_uri":  {redirectURI},
+		"response_type": {"code"},
+		"scope":         {googleAdsScope},
+		"access_type":   {"offline"},
+		"prompt":        {"consent"},
+		"state":         {state},
+	}
+	return installedAppAuthEndpoint + "?" + v.Encode()
+}
+
+// randomState returns a URL-safe random string suitable for use as the
+// OAuth2 "state" parameter.
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// exchangeAuthorizationCode exchanges an authorization code obtained from
+// the loopback callback for an access and refresh token. ClientID and
+// ClientSecret are read through resolvedField so a reference-backed value
+// is exchanged resolved, not as the literal reference string.
+func (c *ConfigFile) exchangeAuthorizationCode(ctx context.Context, code, redirectURI string) (tokenResponse, error) {
+	clientID, err := c.resolvedField(ctx, ClientID)
+	if err != nil {
+		return tokenResponse{}, fmt.Errorf("resolving %s: %s", ClientID, err)
+	}
+	clientSecret, err := c.resolvedField(ctx, ClientSecret)
+	if err != nil {
+		return tokenResponse{}, fmt.Errorf("resolving %s: %s", ClientSecret, err)
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+		"redirect_uri":  {redirectURI},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return tokenResponse{}, fmt.Errorf("token endpoint returned %s: %s", resp.Status, body)
+	}
+
+	var tok tokenResponse
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return tokenResponse{}, fmt.Errorf("parsing token endpoint response: %s", err)
+	}
+	return tok, nil
+}
@@ -0,0 +1,204 @@
+// Copyright 2019 Google LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diag
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuthorizeInstalledApp(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Error getting current dir: %s", err)
+	}
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if got := r.FormValue("grant_type"); got != "authorization_code" {
+			t.Errorf("token request grant_type: got %q, want %q", got, "authorization_code")
+		}
+		if got := r.FormValue("code"); got != "GOOD_CODE" {
+			t.Errorf("token request code: got %q, want %q", got, "GOOD_CODE")
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"access_token":"ACCESS_TOKEN","refresh_token":"NEW_REFRESH_TOKEN","expires_in":3599,"token_type":"Bearer"}`))
+	}))
+	defer tokenServer.Close()
+	tokenEndpoint = tokenServer.URL
+
+	consentURLCh := make(chan string, 1)
+	notifyConsentURL = func(consentURL string) { consentURLCh <- consentURL }
+
+	cfg := ConfigFile{
+		Lang:     "python",
+		Filepath: filepath.Join(dir, "testdata"),
+		Filename: "python_config_auth",
+		ConfigKeys: ConfigKeys{
+			ClientID:     "GoodClientID",
+			ClientSecret: "GoodClientSecret",
+		},
+	}
+
+	resultCh := make(chan struct {
+		backup string
+		err    error
+	}, 1)
+	go func() {
+		backup, err := cfg.AuthorizeInstalledApp(context.Background())
+		resultCh <- struct {
+			backup string
+			err    error
+		}{backup, err}
+	}()
+
+	consentURL := <-consentURLCh
+	parsed, err := url.Parse(consentURL)
+	if err != nil {
+		t.Fatalf("Error parsing consent URL %q: %s", consentURL, err)
+	}
+
+	redirectURI := parsed.Query().Get("redirect_uri")
+	state := parsed.Query().Get("state")
+	if redirectURI == "" || state == "" {
+		t.Fatalf("Consent URL %q is missing redirect_uri or state", consentURL)
+	}
+
+	callbackURL := redirectURI + "?code=GOOD_CODE&state=" + state
+	resp, err := http.Get(callbackURL)
+	if err != nil {
+		t.Fatalf("Error driving the callback URL %q: %s", callbackURL, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Callback response status: got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	result := <-resultCh
+	if result.err != nil {
+		t.Fatalf("AuthorizeInstalledApp() error: %s", result.err)
+	}
+
+	config := cfg.GetFilepath()
+	defer func() {
+		if err := os.Remove(config); err != nil {
+			t.Errorf("Error cleaning up the new config file (%s): %s", config, err)
+		}
+		if err := os.Rename(result.backup, config); err != nil {
+			t.Errorf("Error restoring the config file from %s to %s: %s", result.backup, config, err)
+		}
+	}()
+
+	got, err := ioutil.ReadFile(config)
+	if err != nil {
+		t.Fatalf("Error reading rewritten config file: %s", err)
+	}
+	if !strings.Contains(string(got), "refresh_token:NEW_REFRESH_TOKEN") {
+		t.Errorf("Rewritten config file %s does not contain the new refresh token:\n%s", config, got)
+	}
+}
+
+func TestAuthorizeInstalledAppResolvesSecretReferences(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Error getting current dir: %s", err)
+	}
+
+	var gotClientID, gotClientSecret string
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		gotClientID = r.FormValue("client_id")
+		gotClientSecret = r.FormValue("client_secret")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"access_token":"ACCESS_TOKEN","refresh_token":"NEW_REFRESH_TOKEN","expires_in":3599,"token_type":"Bearer"}`))
+	}))
+	defer tokenServer.Close()
+	tokenEndpoint = tokenServer.URL
+
+	consentURLCh := make(chan string, 1)
+	notifyConsentURL = func(consentURL string) { consentURLCh <- consentURL }
+
+	cfg := ConfigFile{
+		Lang:     "python",
+		Filepath: filepath.Join(dir, "testdata"),
+		Filename: "python_config_auth",
+		ConfigKeys: ConfigKeys{
+			ClientID:     "vault://secret/data/google-ads#client_id",
+			ClientSecret: "vault://secret/data/google-ads#client_secret",
+		},
+		Resolver: fakeResolver{
+			"vault://secret/data/google-ads#client_id":     "ResolvedClientID",
+			"vault://secret/data/google-ads#client_secret": "ResolvedClientSecret",
+		},
+	}
+
+	resultCh := make(chan struct {
+		backup string
+		err    error
+	}, 1)
+	go func() {
+		backup, err := cfg.AuthorizeInstalledApp(context.Background())
+		resultCh <- struct {
+			backup string
+			err    error
+		}{backup, err}
+	}()
+
+	consentURL := <-consentURLCh
+	parsed, err := url.Parse(consentURL)
+	if err != nil {
+		t.Fatalf("Error parsing consent URL %q: %s", consentURL, err)
+	}
+	if got := parsed.Query().Get("client_id"); got != "ResolvedClientID" {
+		t.Errorf("consent URL client_id: got %q, want %q", got, "ResolvedClientID")
+	}
+
+	redirectURI := parsed.Query().Get("redirect_uri")
+	state := parsed.Query().Get("state")
+	if redirectURI == "" || state == "" {
+		t.Fatalf("Consent URL %q is missing redirect_uri or state", consentURL)
+	}
+
+	callbackURL := redirectURI + "?code=GOOD_CODE&state=" + state
+	resp, err := http.Get(callbackURL)
+	if err != nil {
+		t.Fatalf("Error driving the callback URL %q: %s", callbackURL, err)
+	}
+	resp.Body.Close()
+
+	result := <-resultCh
+	if result.err != nil {
+		t.Fatalf("AuthorizeInstalledApp() error: %s", result.err)
+	}
+
+	config := cfg.GetFilepath()
+	defer func() {
+		os.Remove(config)
+		os.Rename(result.backup, config)
+	}()
+
+	if gotClientID != "ResolvedClientID" {
+		t.Errorf("token request client_id: got %q, want %q", gotClientID, "ResolvedClientID")
+	}
+	if gotClientSecret != "ResolvedClientSecret" {
+		t.Errorf("token request client_secret: got %q, want %q", gotClientSecret, "ResolvedClientSecret")
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2019 Google LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package netcheck runs staged network diagnostics against the Google Ads
+// API host, to be invoked once ConfigFile.Validate (and, ideally,
+// ConfigFile.Probe) have already confirmed the credentials themselves are
+// well-formed. The DNS, TCP and TLS stages are deliberately low-level
+// (a bare dial, a handshake with certificate verification deferred) so a
+// firewall or TLS-inspecting proxy shows up distinctly from an API error.
+// The health and authenticated API stages are real gRPC, over
+// grpc.Dial/WithBlock, via the adsgrpc package: a proxy that passes plain
+// HTTPS but breaks or inspects HTTP/2 streams needs an actual HTTP/2 gRPC
+// round trip to catch, which a REST request wouldn't exercise.
+package netcheck
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/googleads/google-ads-doctor/oauthdoctor/diag/adsgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// DefaultHost is the Google Ads API host netcheck diagnoses by default.
+const DefaultHost = "googleads.googleapis.com"
+
+// dialPort is the TLS and gRPC port netcheck dials, declared as a var
+// rather than a const so tests can point it at a local listener's port.
+var dialPort = "443"
+
+// grpcCreds are the transport credentials used to dial the gRPC stages. A
+// var, rather than building credentials.NewTLS inline, so tests can swap in
+// insecure.NewCredentials() to talk to a fake, non-TLS gRPC server.
+var grpcCreds credentials.TransportCredentials = credentials.NewTLS(&tls.Config{})
+
+// grpcTarget overrides the address the gRPC stages dial, when set. In
+// production it's empty and Diagnose dials host:dialPort like every other
+// stage; tests set it to a fake gRPC server's address, which (unlike the
+// TLS stage's httptest.Server) can't share a listener with the TCP/TLS
+// stages since it speaks real HTTP/2 gRPC framing, not plain TLS.
+var grpcTarget string
+
+// Stage reports the outcome of one diagnostic step. Detail carries
+// stage-specific information (resolved IPs, the certificate chain summary,
+// an HTTP status code) so the human-readable report and the JSON report
+// share a single source of truth.
+type Stage struct {
+	Name     string                 `json:"name"`
+	OK       bool                   `json:"ok"`
+	Err      string                 `json:"error,omitempty"`
+	Duration time.Duration          `json:"durationMs"`
+	Proxy    string                 `json:"proxy,omitempty"`
+	Detail   map[string]interface{} `json:"detail,omitempty"`
+}
+
+// MarshalJSON renders Duration as whole milliseconds instead of
+// time.Duration's default nanosecond count, which is what a human reading
+// the JSON report alongside a bug report expects.
+func (s Stage) MarshalJSON() ([]byte, error) {
+	type alias Stage
+	return json.Marshal(struct {
+		alias
+		Duration int64 `json:"durationMs"`
+	}{alias(s), s.Duration.Milliseconds()})
+}
+
+// Report is the full result of Diagnose: one Stage per step, in the order
+// they ran, plus a top-level verdict a caller can check without inspecting
+// every stage.
+type Report struct {
+	Host string `json:"host"`
+
+	DNS             Stage `json:"dns"`
+	TCPDial         Stage `json:"tcpDial"`
+	TLSHandshake    Stage `json:"tlsHandshake"`
+	HealthCheck     Stage `json:"healthCheck"`
+	APIReachability Stage `json:"apiReachability"`
+
+	// LikelyMITM is true when the TLS stage completed but the served leaf
+	// certificate doesn't chain to a root in the system trust store: the
+	// signature of a corporate TLS-inspecting proxy.
+	LikelyMITM bool `json:"likelyMitm"`
+
+	OK bool `json:"ok"`
+}
+
+// JSON renders the report as indented JSON, suitable for attaching to a bug
+// report alongside the human-readable Print output.
+func (r Report) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// proxyFor reports which proxy (if any) a request to rawurl would be routed
+// through, honoring HTTPS_PROXY/HTTP_PROXY/NO_PROXY the same way
+// http.DefaultTransport does.
+func proxyFor(rawurl string) string {
+	req, err := http.NewRequest(http.MethodGet, rawurl, nil)
+	if err != nil {
+		return ""
+	}
+	proxyURL, err := http.ProxyFromEnvironment(req)
+	if err != nil || proxyURL == nil {
+		return ""
+	}
+	return proxyURL.String()
+}
+
+// Diagnose runs the DNS, TCP, TLS, unauthenticated health check and
+// authenticated API stages against host, in order, stopping early if a
+// stage other than the authenticated one fails outright (there's no point
+// timing an API call over a connection that never completed a TLS
+// handshake). accessToken, devToken and loginCustomerID are used for the
+// authenticated stage exactly as ConfigFile.Probe uses them; loginCustomerID
+// may be empty.
+func Diagnose(ctx context.Context, host, accessToken, devToken, loginCustomerID string) Report {
+	report := Report{Host: host}
+
+	report.DNS = resolveHost(ctx, host)
+	if !report.DNS.OK {
+		return report
+	}
+
+	report.TCPDial = dialTCP(ctx, host)
+	if !report.TCPDial.OK {
+		return report
+	}
+
+	var certs []*x509.Certificate
+	report.TLSHandshake, certs = tlsHandshake(ctx, host)
+	if !report.TLSHandshake.OK {
+		return report
+	}
+	report.LikelyMITM = isLikelyMITM(certs)
+
+	addr := net.JoinHostPort(host, dialPort)
+	proxy := proxyFor("https://" + addr)
+
+	target := addr
+	if grpcTarget != "" {
+		target = grpcTarget
+	}
+
+	start := time.Now()
+	conn, err := adsgrpc.Dial(ctx, target, grpcCreds)
+	if err != nil {
+		dialErr := Stage{Proxy: proxy, Duration: time.Since(start), Err: err.Error()}
+		report.HealthCheck, report.APIReachability = dialErr, dialErr
+		report.HealthCheck.Name, report.APIReachability.Name = "health check", "authenticated API call"
+		return report
+	}
+	defer conn.Close()
+
+	report.HealthCheck = checkHealth(ctx, conn, proxy)
+	report.APIReachability = checkAPIReachability(ctx, conn, proxy, accessToken, devToken, loginCustomerID)
+
+	report.OK = report.HealthCheck.OK && report.APIReachability.OK
+	return report
+}
+
+// resolveHost times a DNS lookup of host and records the resolved
+// addresses.
+func resolveHost(ctx context.Context, host string) Stage {
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	stage := Stage{Name: "DNS resolution", Duration: time.Since(start)}
+	if err != nil {
+		stage.Err = err.Error()
+		return stage
+	}
+	stage.OK = true
+	stage.Detail = map[string]interface{}{"addresses": addrs}
+	return stage
+}
+
+// dialTCP times a bare TCP connection to host:dialPort, without doing a TLS
+// handshake, so a firewall blocking the port shows up distinctly from a TLS
+// failure.
+func dialTCP(ctx context.Context, host string) Stage {
+	addr := net.JoinHostPort(host, dialPort)
+	start := time.Now()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	stage := Stage{Name: "TCP dial", Duration: time.Since(start)}
+	if err != nil {
+		stage.Err = err.Error()
+		return stage
+	}
+	conn.Close()
+	stage.OK = true
+	return stage
+}
+
+// tlsHandshake times a TLS handshake to host:dialPort and summarizes the
+// certificate chain the server presented. Verification is skipped at the
+// handshake layer and instead performed afterwards by isLikelyMITM, so a
+// corporate proxy re-signing the certificate under a private root is
+// reported as a diagnostic flag rather than aborting the handshake outright.
+func tlsHandshake(ctx context.Context, host string) (Stage, []*x509.Certificate) {
+	addr := net.JoinHostPort(host, dialPort)
+	start := time.Now()
+	dialer := &tls.Dialer{Config: &tls.Config{ServerName: host, InsecureSkipVerify: true}}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	stage := Stage{Name: "TLS handshake", Duration: time.Since(start)}
+	if err != nil {
+		stage.Err = err.Error()
+		return stage, nil
+	}
+	defer conn.Close()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	stage.OK = true
+	stage.Detail = map[string]interface{}{"certificateChain": certChainSummary(state.PeerCertificates)}
+	return stage, state.PeerCertificates
+}
+
+// certChainSummary renders each certificate's subject and expiry for the
+// JSON report, without embedding the raw DER.
+func certChainSummary(chain []*x509.Certificate) []map[string]string {
+	summary := make([]map[string]string, 0, len(chain))
+	for _, cert := range chain {
+		summary = append(summary, map[string]string{
+			"subject":   cert.Subject.String(),
+			"issuer":    cert.Issuer.String(),
+			"notAfter":  cert.NotAfter.Format(time.RFC3339),
+			"notBefore": cert.NotBefore.Format(time.RFC3339),
+		})
+	}
+	return summary
+}
+
+// isLikelyMITM reports whether the leaf certificate in chain fails to
+// verify against the system trust store: the common signature of a
+// corporate TLS-inspecting proxy reissuing googleads.googleapis.com's
+// certificate under a private root.
+func isLikelyMITM(chain []*x509.Certificate) bool {
+	if len(chain) == 0 {
+		return false
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		return false
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = chain[0].Verify(x509.VerifyOptions{Roots: pool, Intermediates: intermediates})
+	return err != nil
+}
+
+// checkHealth runs the unauthenticated gRPC health check over conn: a proxy
+// that passes plain HTTPS but breaks or inspects HTTP/2 streams fails here
+// even though it would pass a plain TLS handshake.
+func checkHealth(ctx context.Context, conn *grpc.ClientConn, proxy string) Stage {
+	stage := Stage{Name: "health check", Proxy: proxy}
+	start := time.Now()
+	err := adsgrpc.CheckHealth(ctx, conn)
+	stage.Duration = time.Since(start)
+	if err != nil {
+		stage.Err = err.Error()
+		return stage
+	}
+	stage.OK = true
+	return stage
+}
+
+// checkAPIReachability calls CustomerService.ListAccessibleCustomers over
+// conn to confirm the developer token (and, if set, the login customer ID)
+// are accepted end-to-end.
+func checkAPIReachability(ctx context.Context, conn *grpc.ClientConn, proxy, accessToken, devToken, loginCustomerID string) Stage {
+	stage := Stage{Name: "authenticated API call", Proxy: proxy}
+	start := time.Now()
+	names, err := adsgrpc.ListAccessibleCustomers(ctx, conn, accessToken, devToken, loginCustomerID)
+	stage.Duration = time.Since(start)
+	if err != nil {
+		stage.Err = err.Error()
+		return stage
+	}
+	stage.OK = true
+	stage.Detail = map[string]interface{}{"accessibleCustomers": len(names)}
+	return stage
+}
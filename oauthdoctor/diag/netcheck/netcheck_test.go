@@ -0,0 +1,189 @@
+// Copyright 2019 Google LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netcheck
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fakeHealthServer always reports a fixed status.
+type fakeHealthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+	status grpc_health_v1.HealthCheckResponse_ServingStatus
+}
+
+func (f *fakeHealthServer) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	return &grpc_health_v1.HealthCheckResponse{Status: f.status}, nil
+}
+
+// fakeCustomerService serves ListAccessibleCustomers over a raw byte codec,
+// the way the real Google Ads API server would over the real one, and
+// records the developer-token metadata it was called with.
+func fakeCustomerService(gotDevToken *string) *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: "google.ads.googleads.v16.services.CustomerService",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			{
+				MethodName: "ListAccessibleCustomers",
+				Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+					var req []byte
+					if err := dec(&req); err != nil {
+						return nil, err
+					}
+					if md, ok := metadata.FromIncomingContext(ctx); ok {
+						if vals := md.Get("developer-token"); len(vals) > 0 {
+							*gotDevToken = vals[0]
+						}
+					}
+					var resp []byte
+					resp = protowire.AppendTag(resp, 1, protowire.BytesType)
+					resp = protowire.AppendString(resp, "customers/111")
+					return resp, nil
+				},
+			},
+		},
+	}
+}
+
+// startFakeGRPCServer starts a gRPC server implementing both the standard
+// health-checking protocol and a minimal CustomerService, and returns its
+// address. Both services share the one codec adsgrpc registers globally, so
+// a plain grpc.NewServer (no ForceServerCodec) can host them together: the
+// codec dispatches on each message's own type rather than per-server
+// configuration.
+func startFakeGRPCServer(t *testing.T, healthStatus grpc_health_v1.HealthCheckResponse_ServingStatus, gotDevToken *string) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting fake gRPC listener: %s", err)
+	}
+
+	srv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(srv, &fakeHealthServer{status: healthStatus})
+	srv.RegisterService(fakeCustomerService(gotDevToken), nil)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	return lis.Addr().String()
+}
+
+func TestDiagnose(t *testing.T) {
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer tlsSrv.Close()
+
+	_, tlsPort, err := net.SplitHostPort(strings.TrimPrefix(tlsSrv.URL, "https://"))
+	if err != nil {
+		t.Fatalf("Error splitting test server address: %s", err)
+	}
+
+	var gotDevToken string
+	grpcAddr := startFakeGRPCServer(t, grpc_health_v1.HealthCheckResponse_SERVING, &gotDevToken)
+
+	oldPort, oldCreds, oldTarget := dialPort, grpcCreds, grpcTarget
+	dialPort, grpcCreds, grpcTarget = tlsPort, insecure.NewCredentials(), grpcAddr
+	defer func() { dialPort, grpcCreds, grpcTarget = oldPort, oldCreds, oldTarget }()
+
+	report := Diagnose(context.Background(), "127.0.0.1", "ACCESS_TOKEN", "GoodDevToken", "")
+
+	if !report.DNS.OK {
+		t.Errorf("DNS stage: got %+v, want OK", report.DNS)
+	}
+	if !report.TCPDial.OK {
+		t.Errorf("TCPDial stage: got %+v, want OK", report.TCPDial)
+	}
+	if !report.TLSHandshake.OK {
+		t.Errorf("TLSHandshake stage: got %+v, want OK", report.TLSHandshake)
+	}
+	if !report.LikelyMITM {
+		t.Error("LikelyMITM: got false, want true for a self-signed test server")
+	}
+	if !report.HealthCheck.OK {
+		t.Errorf("HealthCheck stage: got %+v, want OK", report.HealthCheck)
+	}
+	if !report.APIReachability.OK {
+		t.Errorf("APIReachability stage: got %+v, want OK", report.APIReachability)
+	}
+	if got := report.APIReachability.Detail["accessibleCustomers"]; got != 1 {
+		t.Errorf("APIReachability accessibleCustomers: got %v, want 1", got)
+	}
+	if !report.OK {
+		t.Error("report.OK: got false, want true")
+	}
+	if gotDevToken != "GoodDevToken" {
+		t.Errorf("developer-token metadata: got %q, want %q", gotDevToken, "GoodDevToken")
+	}
+}
+
+func TestDiagnoseHealthCheckNotServing(t *testing.T) {
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer tlsSrv.Close()
+
+	_, tlsPort, err := net.SplitHostPort(strings.TrimPrefix(tlsSrv.URL, "https://"))
+	if err != nil {
+		t.Fatalf("Error splitting test server address: %s", err)
+	}
+
+	var gotDevToken string
+	grpcAddr := startFakeGRPCServer(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, &gotDevToken)
+
+	oldPort, oldCreds, oldTarget := dialPort, grpcCreds, grpcTarget
+	dialPort, grpcCreds, grpcTarget = tlsPort, insecure.NewCredentials(), grpcAddr
+	defer func() { dialPort, grpcCreds, grpcTarget = oldPort, oldCreds, oldTarget }()
+
+	report := Diagnose(context.Background(), "127.0.0.1", "ACCESS_TOKEN", "GoodDevToken", "")
+
+	if report.HealthCheck.OK {
+		t.Error("HealthCheck stage: got OK, want failure for a NOT_SERVING status")
+	}
+	if report.OK {
+		t.Error("report.OK: got true, want false")
+	}
+}
+
+func TestDiagnoseDNSFailure(t *testing.T) {
+	report := Diagnose(context.Background(), "this-host-does-not-exist.invalid", "", "", "")
+
+	if report.DNS.OK {
+		t.Error("DNS stage: got OK, want failure for an unresolvable host")
+	}
+	if report.OK {
+		t.Error("report.OK: got true, want false")
+	}
+}
+
+func TestIsLikelyMITMEmptyChain(t *testing.T) {
+	if got := isLikelyMITM(nil); got != false {
+		t.Errorf("isLikelyMITM(nil): got %v, want false", got)
+	}
+	if got := isLikelyMITM([]*x509.Certificate{}); got != false {
+		t.Errorf("isLikelyMITM(empty): got %v, want false", got)
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright 2019 Google LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diag
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/googleads/google-ads-doctor/oauthdoctor/diag/adsgrpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// tokenEndpoint and googleAdsAPIAddr are declared as vars, not consts, so
+// tests can point them at an httptest.Server and a fake gRPC server
+// respectively.
+var (
+	tokenEndpoint    = "https://oauth2.googleapis.com/token"
+	googleAdsAPIAddr = "googleads.googleapis.com:443"
+)
+
+// grpcCreds are the transport credentials used to dial googleAdsAPIAddr. A
+// var, rather than building credentials.NewTLS inline, so tests can swap in
+// insecure.NewCredentials() to talk to a fake, non-TLS gRPC server.
+var grpcCreds credentials.TransportCredentials = credentials.NewTLS(&tls.Config{})
+
+// googleAdsScope is the OAuth2 scope required to call the Google Ads API.
+const googleAdsScope = "https://www.googleapis.com/auth/adwords"
+
+// jwtBearerGrantType is the grant_type used when exchanging a service
+// account JWT assertion for an access token.
+const jwtBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// ProbeResult reports the outcome of a single network stage of Probe.
+type ProbeResult struct {
+	// OK is true if the stage completed and returned a successful status.
+	OK bool
+	// StatusCode is the HTTP status code returned by the stage, or zero if
+	// the request never got a response (e.g. a DNS or dial failure).
+	StatusCode int
+	// Body is the raw response body, kept around so a failure can be
+	// attached verbatim to a bug report.
+	Body string
+	// Err describes what went wrong, distinguishing a network failure from
+	// an HTTP error status. It is nil when OK is true.
+	Err error
+}
+
+// ProbeReport is the result of ConfigFile.Probe: whether the credentials in
+// a config file actually work against Google's servers, not just whether
+// they're well-formed.
+type ProbeReport struct {
+	// TokenExchange is the outcome of exchanging the configured credentials
+	// (a refresh token, or a signed service account JWT) for an access
+	// token at tokenEndpoint.
+	TokenExchange ProbeResult
+	// APIReachability is the outcome of calling
+	// customers:listAccessibleCustomers with the access token obtained
+	// above, the developer token, and (if set) the login customer ID.
+	APIReachability ProbeResult
+
+	AccessToken      string
+	ExpiresInSeconds int
+	Scope            string
+}
+
+// tokenResponse is the subset of a Google OAuth2 token endpoint response
+// Probe cares about.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Probe goes beyond the structural checks in Validate and actually
+// exercises the configured credentials against Google's endpoints: it
+// exchanges them for an access token, then makes a minimal
+// customers:listAccessibleCustomers call to confirm the developer token and
+// (optional) login customer ID are accepted too. Each stage's result is
+// returned in the ProbeReport even when Probe also returns a non-nil error,
+// so a caller can tell a bad refresh token apart from a revoked developer
+// token or a missing login customer ID.
+func (c *ConfigFile) Probe(ctx context.Context) (ProbeReport, error) {
+	var report ProbeReport
+
+	tok, tokenResult := c.exchangeToken(ctx)
+	report.TokenExchange = tokenResult
+	if !tokenResult.OK {
+		return report, fmt.Errorf("OAuth2 token exchange failed: %s", tokenResult.Err)
+	}
+
+	report.AccessToken = tok.AccessToken
+	report.ExpiresInSeconds = tok.ExpiresIn
+	report.Scope = tok.Scope
+
+	report.APIReachability = c.checkAPIReachability(ctx, tok.AccessToken)
+	if !report.APIReachability.OK {
+		return report, fmt.Errorf("Google Ads API call failed: %s", report.APIReachability.Err)
+	}
+
+	return report, nil
+}
+
+// exchangeToken posts the OAuth2 grant appropriate for c.OAuthType to
+// tokenEndpoint and parses the resulting access token. ClientID,
+// ClientSecret and RefreshToken are read through resolvedField so a config
+// file sourcing them from Vault/GSM/age is exchanged with the resolved
+// secret, not the literal reference string.
+func (c *ConfigFile) exchangeToken(ctx context.Context) (tokenResponse, ProbeResult) {
+	var form url.Values
+
+	if c.OAuthType == ServiceAccount {
+		assertion, err := c.signServiceAccountJWT(ctx)
+		if err != nil {
+			return tokenResponse{}, ProbeResult{Err: fmt.Errorf("signing service account JWT: %s", err)}
+		}
+		form = url.Values{
+			"grant_type": {jwtBearerGrantType},
+			"assertion":  {assertion},
+		}
+	} else {
+		clientID, err := c.resolvedField(ctx, ClientID)
+		if err != nil {
+			return tokenResponse{}, ProbeResult{Err: fmt.Errorf("resolving %s: %s", ClientID, err)}
+		}
+		clientSecret, err := c.resolvedField(ctx, ClientSecret)
+		if err != nil {
+			return tokenResponse{}, ProbeResult{Err: fmt.Errorf("resolving %s: %s", ClientSecret, err)}
+		}
+		refreshToken, err := c.resolvedField(ctx, RefreshToken)
+		if err != nil {
+			return tokenResponse{}, ProbeResult{Err: fmt.Errorf("resolving %s: %s", RefreshToken, err)}
+		}
+		form = url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {clientID},
+			"client_secret": {clientSecret},
+			"refresh_token": {refreshToken},
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return tokenResponse{}, ProbeResult{Err: fmt.Errorf("building token request: %s", err)}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return tokenResponse{}, ProbeResult{Err: fmt.Errorf("contacting token endpoint: %s", err)}
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	result := ProbeResult{StatusCode: resp.StatusCode, Body: string(body)}
+
+	if resp.StatusCode != http.StatusOK {
+		result.Err = fmt.Errorf("token endpoint returned %s: %s", resp.Status, body)
+		return tokenResponse{}, result
+	}
+
+	var tok tokenResponse
+	if err := json.Unmarshal(body, &tok); err != nil {
+		result.Err = fmt.Errorf("parsing token endpoint response: %s", err)
+		return tokenResponse{}, result
+	}
+
+	result.OK = true
+	return tok, result
+}
+
+// checkAPIReachability calls CustomerService.ListAccessibleCustomers over a
+// real gRPC connection, the way the Google Ads API client actually talks to
+// the API, to confirm the developer token (and, if set, the login customer
+// ID) are accepted end-to-end. Both are read through resolvedField so a
+// reference-backed developer token or login customer ID is sent resolved,
+// not as the literal reference string.
+func (c *ConfigFile) checkAPIReachability(ctx context.Context, accessToken string) ProbeResult {
+	devToken, err := c.resolvedField(ctx, DevToken)
+	if err != nil {
+		return ProbeResult{Err: fmt.Errorf("resolving %s: %s", DevToken, err)}
+	}
+	loginCustomerID, err := c.resolvedField(ctx, LoginCustomerID)
+	if err != nil {
+		return ProbeResult{Err: fmt.Errorf("resolving %s: %s", LoginCustomerID, err)}
+	}
+
+	conn, err := adsgrpc.Dial(ctx, googleAdsAPIAddr, grpcCreds)
+	if err != nil {
+		return ProbeResult{Err: fmt.Errorf("dialing the Google Ads API: %s", err)}
+	}
+	defer conn.Close()
+
+	names, err := adsgrpc.ListAccessibleCustomers(ctx, conn, accessToken, devToken, loginCustomerID)
+	if err != nil {
+		return ProbeResult{Err: fmt.Errorf("Google Ads API call failed: %s", err)}
+	}
+
+	return ProbeResult{OK: true, Body: strings.Join(names, ",")}
+}
+
+// signServiceAccountJWT builds and signs (RS256) the JWT assertion used to
+// exchange a service account for an access token, impersonating
+// c.ConfigKeys.DelegatedAccount (read through resolvedField, since it may be
+// a secret reference rather than a literal email address).
+func (c *ConfigFile) signServiceAccountJWT(ctx context.Context) (string, error) {
+	block, _ := pem.Decode([]byte(c.ServiceAccountInfo.PrivateKey))
+	if block == nil {
+		return "", errors.New("no PEM block found in service account private key")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("parsing service account private key: %s", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("service account private key is not an RSA key")
+	}
+
+	delegatedAccount, err := c.resolvedField(ctx, DelegatedAccount)
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %s", DelegatedAccount, err)
+	}
+
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   c.ServiceAccountInfo.ClientEmail,
+		"sub":   delegatedAccount,
+		"aud":   tokenEndpoint,
+		"scope": googleAdsScope,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hashed := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", fmt.Errorf("signing JWT assertion: %s", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
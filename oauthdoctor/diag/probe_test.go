@@ -0,0 +1,280 @@
+// Copyright 2019 Google LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diag
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fakeCustomerService implements just enough of CustomerService to serve
+// ListAccessibleCustomers, the way the real Google Ads API server would,
+// optionally rejecting the call with apiErr and recording the
+// developer-token metadata it was called with.
+func fakeCustomerService(gotDevToken *string, apiErr error) *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: "google.ads.googleads.v16.services.CustomerService",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			{
+				MethodName: "ListAccessibleCustomers",
+				Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+					var req []byte
+					if err := dec(&req); err != nil {
+						return nil, err
+					}
+					if md, ok := metadata.FromIncomingContext(ctx); ok {
+						if vals := md.Get("developer-token"); len(vals) > 0 {
+							*gotDevToken = vals[0]
+						}
+					}
+					if apiErr != nil {
+						return nil, apiErr
+					}
+					var resp []byte
+					resp = protowire.AppendTag(resp, 1, protowire.BytesType)
+					resp = protowire.AppendString(resp, "customers/111")
+					return resp, nil
+				},
+			},
+		},
+	}
+}
+
+// startFakeGRPCServer starts a gRPC server implementing CustomerService and
+// returns its address.
+func startFakeGRPCServer(t *testing.T, gotDevToken *string, apiErr error) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting fake gRPC listener: %s", err)
+	}
+
+	srv := grpc.NewServer()
+	srv.RegisterService(fakeCustomerService(gotDevToken, apiErr), nil)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	return lis.Addr().String()
+}
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		desc         string
+		cfg          ConfigFile
+		tokenStatus  int
+		tokenBody    string
+		apiErr       error
+		wantOK       bool
+		wantErrstr   string
+		wantAPICalls bool
+	}{
+		{
+			desc: "Installed app: everything succeeds",
+			cfg: ConfigFile{
+				OAuthType: InstalledApp,
+				ConfigKeys: ConfigKeys{
+					DevToken:     "GoodDevToken",
+					ClientID:     "GoodClientID",
+					ClientSecret: "GoodClientSecret",
+					RefreshToken: "GoodRefreshToken",
+				},
+			},
+			tokenStatus:  http.StatusOK,
+			tokenBody:    `{"access_token":"ACCESS_TOKEN","expires_in":3599,"scope":"` + googleAdsScope + `","token_type":"Bearer"}`,
+			wantOK:       true,
+			wantAPICalls: true,
+		},
+		{
+			desc: "Installed app: revoked refresh token",
+			cfg: ConfigFile{
+				OAuthType: InstalledApp,
+				ConfigKeys: ConfigKeys{
+					DevToken:     "GoodDevToken",
+					ClientID:     "GoodClientID",
+					ClientSecret: "GoodClientSecret",
+					RefreshToken: "RevokedRefreshToken",
+				},
+			},
+			tokenStatus:  http.StatusBadRequest,
+			tokenBody:    `{"error":"invalid_grant","error_description":"Token has been expired or revoked."}`,
+			wantOK:       false,
+			wantErrstr:   "token exchange failed",
+			wantAPICalls: false,
+		},
+		{
+			desc: "Installed app: developer token rejected",
+			cfg: ConfigFile{
+				OAuthType: InstalledApp,
+				ConfigKeys: ConfigKeys{
+					DevToken:     "RevokedDevToken",
+					ClientID:     "GoodClientID",
+					ClientSecret: "GoodClientSecret",
+					RefreshToken: "GoodRefreshToken",
+				},
+			},
+			tokenStatus:  http.StatusOK,
+			tokenBody:    `{"access_token":"ACCESS_TOKEN","expires_in":3599,"token_type":"Bearer"}`,
+			apiErr:       status.Error(403, "developer token not approved"),
+			wantOK:       false,
+			wantErrstr:   "Google Ads API call failed",
+			wantAPICalls: true,
+		},
+	}
+
+	for _, test := range tests {
+		var gotDevToken string
+
+		grpcAddr := startFakeGRPCServer(t, &gotDevToken, test.apiErr)
+
+		tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.tokenStatus)
+			w.Write([]byte(test.tokenBody))
+		}))
+		defer tokenServer.Close()
+
+		oldAddr, oldCreds := googleAdsAPIAddr, grpcCreds
+		tokenEndpoint, googleAdsAPIAddr, grpcCreds = tokenServer.URL, grpcAddr, insecure.NewCredentials()
+		defer func() { googleAdsAPIAddr, grpcCreds = oldAddr, oldCreds }()
+
+		_, err := test.cfg.Probe(context.Background())
+
+		if (err == nil) != test.wantOK {
+			t.Errorf("%s\nProbe() error: got %v, want ok=%v", test.desc, err, test.wantOK)
+		}
+		if test.wantErrstr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErrstr)) {
+			t.Errorf("%s\nProbe() error: got %v, want substring %q", test.desc, err, test.wantErrstr)
+		}
+		gotAPICall := gotDevToken != ""
+		if gotAPICall != test.wantAPICalls {
+			t.Errorf("%s\nGoogle Ads API called: got %v, want %v", test.desc, gotAPICall, test.wantAPICalls)
+		}
+	}
+}
+
+func TestProbeResolvesSecretReferences(t *testing.T) {
+	var gotClientSecret, gotRefreshToken, gotDevToken string
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		gotClientSecret = r.FormValue("client_secret")
+		gotRefreshToken = r.FormValue("refresh_token")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":3599,"token_type":"Bearer"}`))
+	}))
+	defer tokenServer.Close()
+
+	grpcAddr := startFakeGRPCServer(t, &gotDevToken, nil)
+
+	oldAddr, oldCreds := googleAdsAPIAddr, grpcCreds
+	tokenEndpoint, googleAdsAPIAddr, grpcCreds = tokenServer.URL, grpcAddr, insecure.NewCredentials()
+	defer func() { googleAdsAPIAddr, grpcCreds = oldAddr, oldCreds }()
+
+	cfg := ConfigFile{
+		OAuthType: InstalledApp,
+		ConfigKeys: ConfigKeys{
+			DevToken:     "vault://secret/data/google-ads#developer_token",
+			ClientID:     "GoodClientID",
+			ClientSecret: "vault://secret/data/google-ads#client_secret",
+			RefreshToken: "gsm://projects/p/secrets/google-ads-refresh/versions/latest",
+		},
+		Resolver: fakeResolver{
+			"vault://secret/data/google-ads#developer_token":              "ResolvedDevToken",
+			"vault://secret/data/google-ads#client_secret":                "ResolvedClientSecret",
+			"gsm://projects/p/secrets/google-ads-refresh/versions/latest": "ResolvedRefreshToken",
+		},
+	}
+
+	if _, err := cfg.Probe(context.Background()); err != nil {
+		t.Fatalf("Probe() error: %s", err)
+	}
+
+	if gotClientSecret != "ResolvedClientSecret" {
+		t.Errorf("token request client_secret: got %q, want %q", gotClientSecret, "ResolvedClientSecret")
+	}
+	if gotRefreshToken != "ResolvedRefreshToken" {
+		t.Errorf("token request refresh_token: got %q, want %q", gotRefreshToken, "ResolvedRefreshToken")
+	}
+	if gotDevToken != "ResolvedDevToken" {
+		t.Errorf("API request developer-token header: got %q, want %q", gotDevToken, "ResolvedDevToken")
+	}
+}
+
+func TestProbeServiceAccount(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating test RSA key: %s", err)
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error marshaling test RSA key: %s", err)
+	}
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}))
+
+	var gotAssertion string
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		gotAssertion = r.FormValue("assertion")
+		if got := r.FormValue("grant_type"); got != jwtBearerGrantType {
+			t.Errorf("token request grant_type: got %q, want %q", got, jwtBearerGrantType)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"access_token":"ACCESS_TOKEN","expires_in":3599,"token_type":"Bearer"}`))
+	}))
+	defer tokenServer.Close()
+
+	var gotDevToken string
+	grpcAddr := startFakeGRPCServer(t, &gotDevToken, nil)
+
+	oldAddr, oldCreds := googleAdsAPIAddr, grpcCreds
+	tokenEndpoint, googleAdsAPIAddr, grpcCreds = tokenServer.URL, grpcAddr, insecure.NewCredentials()
+	defer func() { googleAdsAPIAddr, grpcCreds = oldAddr, oldCreds }()
+
+	cfg := ConfigFile{
+		OAuthType: ServiceAccount,
+		ConfigKeys: ConfigKeys{
+			DevToken:         "GoodDevToken",
+			DelegatedAccount: "example@some.website.com",
+		},
+		ServiceAccountInfo: ServiceAccountInfo{
+			ClientEmail: "service-account@project.iam.gserviceaccount.com",
+			PrivateKey:  keyPEM,
+		},
+	}
+
+	report, err := cfg.Probe(context.Background())
+	if err != nil {
+		t.Fatalf("Probe() error: %s", err)
+	}
+	if report.AccessToken != "ACCESS_TOKEN" {
+		t.Errorf("Probe() AccessToken: got %q, want %q", report.AccessToken, "ACCESS_TOKEN")
+	}
+	if gotAssertion == "" {
+		t.Error("Probe() did not send a signed JWT assertion")
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 Google LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// AgeResolver resolves age:///path/to/file.age#field references by
+// decrypting file with the age CLI (rather than vendoring an age
+// implementation) and reading field out of the resulting "key: value" YAML.
+type AgeResolver struct {
+	// Identity is the path to the age identity file (private key) used to
+	// decrypt. Defaults to AGE_IDENTITY, falling back to age's own default
+	// of ~/.config/age/keys.txt if unset.
+	Identity string
+
+	// decrypt runs the age CLI; a var on the struct so tests can stub it out
+	// without actually invoking the age binary.
+	decrypt func(ctx context.Context, identity, path string) ([]byte, error)
+}
+
+// NewAgeResolver returns an AgeResolver using AGE_IDENTITY, or age's default
+// identity file location if that's unset.
+func NewAgeResolver() *AgeResolver {
+	return &AgeResolver{
+		Identity: os.Getenv("AGE_IDENTITY"),
+		decrypt:  runAgeCLI,
+	}
+}
+
+// runAgeCLI shells out to `age -d` to decrypt path.
+func runAgeCLI(ctx context.Context, identity, path string) ([]byte, error) {
+	args := []string{"-d"}
+	if identity != "" {
+		args = append(args, "-i", identity)
+	}
+	args = append(args, path)
+
+	cmd := exec.CommandContext(ctx, "age", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("age -d %s: %s: %s", path, err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// Resolve decrypts the file named by ref's path and returns the value of
+// the "<field>: <value>" line named by ref's fragment.
+func (r *AgeResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("parsing age reference %q: %s", ref, err)
+	}
+	if u.Path == "" {
+		return "", fmt.Errorf("resolving %q: missing file path", ref)
+	}
+	if u.Fragment == "" {
+		return "", fmt.Errorf("resolving %q: missing #field naming which key to read out of the decrypted file", ref)
+	}
+
+	decrypt := r.decrypt
+	if decrypt == nil {
+		decrypt = runAgeCLI
+	}
+
+	plaintext, err := decrypt(ctx, r.Identity, u.Path)
+	if err != nil {
+		return "", fmt.Errorf("decrypting %q: %s", ref, err)
+	}
+
+	for _, line := range strings.Split(string(plaintext), "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if strings.TrimSpace(parts[0]) == u.Fragment {
+			return strings.TrimSpace(parts[1]), nil
+		}
+	}
+	return "", fmt.Errorf("decrypted file %q has no field %q", u.Path, u.Fragment)
+}
@@ -0,0 +1,135 @@
+// Copyright 2019 Google LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// gsmMetadataTokenURL is GCE's metadata server endpoint for the default
+// service account's access token, the way Application Default Credentials
+// work on GCP compute products without a separate credentials file.
+const gsmMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// gsmAPIHost is declared as a var, not a const, so tests can point it at an
+// httptest.Server.
+var gsmAPIHost = "https://secretmanager.googleapis.com"
+
+// GSMResolver resolves gsm://<resource name> references against the GCP
+// Secret Manager REST API.
+type GSMResolver struct {
+	// AccessToken, when set, is used as-is instead of fetching one from the
+	// GCE metadata server. Tests set this directly instead of faking ADC.
+	AccessToken string
+}
+
+// NewGSMResolver returns a GSMResolver that fetches an access token from the
+// GCE metadata server on each Resolve call, the default Application Default
+// Credentials path for code running on GCP.
+func NewGSMResolver() *GSMResolver {
+	return &GSMResolver{}
+}
+
+// gsmTokenResponse is the metadata server's access token response shape.
+type gsmTokenResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+// accessToken returns r.AccessToken if set, otherwise fetches one from the
+// GCE metadata server.
+func (r *GSMResolver) accessToken(ctx context.Context) (string, error) {
+	if r.AccessToken != "" {
+		return r.AccessToken, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gsmMetadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching Application Default Credentials from the metadata server: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server returned %s: %s", resp.Status, body)
+	}
+
+	var tok gsmTokenResponse
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return "", fmt.Errorf("parsing metadata server token response: %s", err)
+	}
+	return tok.AccessToken, nil
+}
+
+// gsmAccessResponse is the subset of a Secret Manager AccessSecretVersion
+// response the resolver cares about.
+type gsmAccessResponse struct {
+	Payload struct {
+		Data string `json:"data"`
+	} `json:"payload"`
+}
+
+// Resolve reads the secret version named by ref (everything after
+// gsm://) and returns its decoded payload.
+func (r *GSMResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	name := strings.TrimPrefix(ref, "gsm://")
+	if name == "" {
+		return "", fmt.Errorf("resolving %q: empty Secret Manager resource name", ref)
+	}
+
+	tok, err := r.accessToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("resolving %q: %s", ref, err)
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/%s:access", gsmAPIHost, name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("building Secret Manager request for %q: %s", ref, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("contacting Secret Manager for %q: %s", ref, err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Secret Manager returned %s for %q: %s", resp.Status, ref, body)
+	}
+
+	var parsed gsmAccessResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return "", fmt.Errorf("parsing Secret Manager response for %q: %s", ref, err)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(parsed.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("decoding Secret Manager payload for %q: %s", ref, err)
+	}
+	return string(decoded), nil
+}
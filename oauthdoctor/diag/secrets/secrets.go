@@ -0,0 +1,75 @@
+// Copyright 2019 Google LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package secrets lets a config value be a reference to a secret stored in
+// Vault, GCP Secret Manager or an age-encrypted file, instead of a literal.
+// A reference looks like a URL: vault://secret/data/google-ads#refresh_token,
+// gsm://projects/p/secrets/google-ads-refresh/versions/latest, or
+// age:///path/to/keys.age#refresh_token.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Resolver resolves a single secret reference to its plaintext value.
+type Resolver interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// schemes are the reference schemes oauthdoctor understands.
+var schemes = []string{"vault", "gsm", "age"}
+
+// IsReference reports whether val is a secret reference (one of the schemes
+// above), as opposed to a literal config value.
+func IsReference(val string) bool {
+	for _, scheme := range schemes {
+		if strings.HasPrefix(val, scheme+"://") {
+			return true
+		}
+	}
+	return false
+}
+
+// Multiplexer dispatches a reference to the Resolver registered for its
+// scheme.
+type Multiplexer map[string]Resolver
+
+// Resolve parses ref's scheme and hands it to the matching Resolver.
+func (m Multiplexer) Resolve(ctx context.Context, ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("parsing secret reference %q: %s", ref, err)
+	}
+
+	r, ok := m[u.Scheme]
+	if !ok {
+		return "", fmt.Errorf("no secret resolver registered for scheme %q", u.Scheme)
+	}
+	return r.Resolve(ctx, ref)
+}
+
+// DefaultMultiplexer returns the out-of-the-box Multiplexer: vault:// goes
+// to Vault's KV v2 API via VAULT_ADDR/VAULT_TOKEN, gsm:// to GCP Secret
+// Manager via Application Default Credentials, and age:// to an
+// age-encrypted file decrypted with the age CLI.
+func DefaultMultiplexer() Multiplexer {
+	return Multiplexer{
+		"vault": NewVaultResolver(),
+		"gsm":   NewGSMResolver(),
+		"age":   NewAgeResolver(),
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2019 Google LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsReference(t *testing.T) {
+	tests := []struct {
+		val  string
+		want bool
+	}{
+		{"vault://secret/data/google-ads#refresh_token", true},
+		{"gsm://projects/p/secrets/google-ads-refresh/versions/latest", true},
+		{"age:///path/to/keys.age#refresh_token", true},
+		{"GoodRefreshToken", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := IsReference(test.val); got != test.want {
+			t.Errorf("IsReference(%q): got %v, want %v", test.val, got, test.want)
+		}
+	}
+}
+
+// fakeResolver is a Resolver backed by an in-memory map, so tests can
+// exercise secret resolution without a live Vault/GSM/age backend.
+type fakeResolver struct {
+	values map[string]string
+	err    error
+}
+
+func (f *fakeResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	val, ok := f.values[ref]
+	if !ok {
+		return "", fmt.Errorf("fakeResolver: no value registered for %q", ref)
+	}
+	return val, nil
+}
+
+func TestMultiplexerResolve(t *testing.T) {
+	tests := []struct {
+		desc    string
+		mux     Multiplexer
+		ref     string
+		want    string
+		wantErr string
+	}{
+		{
+			desc: "dispatches to the registered scheme",
+			mux: Multiplexer{
+				"vault": &fakeResolver{values: map[string]string{
+					"vault://secret/data/google-ads#refresh_token": "ResolvedRefreshToken",
+				}},
+			},
+			ref:  "vault://secret/data/google-ads#refresh_token",
+			want: "ResolvedRefreshToken",
+		},
+		{
+			desc:    "no resolver registered for scheme",
+			mux:     Multiplexer{},
+			ref:     "gsm://projects/p/secrets/x/versions/latest",
+			wantErr: `no secret resolver registered for scheme "gsm"`,
+		},
+		{
+			desc: "underlying resolver error propagates",
+			mux: Multiplexer{
+				"age": &fakeResolver{err: fmt.Errorf("identity file not found")},
+			},
+			ref:     "age:///path/to/keys.age#refresh_token",
+			wantErr: "identity file not found",
+		},
+	}
+
+	for _, test := range tests {
+		got, err := test.mux.Resolve(context.Background(), test.ref)
+
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s\nResolve() error: got %v, want substring %q", test.desc, err, test.wantErr)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s\nResolve() error: %s", test.desc, err)
+		}
+		if got != test.want {
+			t.Errorf("%s\nResolve(): got %q, want %q", test.desc, got, test.want)
+		}
+	}
+}
+
+func TestVaultResolver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Vault-Token"); got != "GoodVaultToken" {
+			t.Errorf("X-Vault-Token header: got %q, want %q", got, "GoodVaultToken")
+		}
+		if r.URL.Path != "/v1/secret/data/google-ads" {
+			t.Errorf("request path: got %q, want %q", r.URL.Path, "/v1/secret/data/google-ads")
+		}
+		w.Write([]byte(`{"data":{"data":{"refresh_token":"ResolvedRefreshToken"}}}`))
+	}))
+	defer srv.Close()
+
+	r := &VaultResolver{Addr: srv.URL, Token: "GoodVaultToken"}
+	got, err := r.Resolve(context.Background(), "vault://secret/data/google-ads#refresh_token")
+	if err != nil {
+		t.Fatalf("Resolve() error: %s", err)
+	}
+	if got != "ResolvedRefreshToken" {
+		t.Errorf("Resolve(): got %q, want %q", got, "ResolvedRefreshToken")
+	}
+}
+
+func TestVaultResolverMissingField(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{"data":{"developer_token":"GoodDevToken"}}}`))
+	}))
+	defer srv.Close()
+
+	r := &VaultResolver{Addr: srv.URL, Token: "GoodVaultToken"}
+	_, err := r.Resolve(context.Background(), "vault://secret/data/google-ads#refresh_token")
+	if err == nil || !strings.Contains(err.Error(), "no field") {
+		t.Errorf("Resolve() error: got %v, want a missing-field error", err)
+	}
+}
+
+func TestGSMResolver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer GoodAccessToken" {
+			t.Errorf("Authorization header: got %q, want %q", got, "Bearer GoodAccessToken")
+		}
+		wantPath := "/v1/projects/p/secrets/google-ads-refresh/versions/latest:access"
+		if r.URL.Path != wantPath {
+			t.Errorf("request path: got %q, want %q", r.URL.Path, wantPath)
+		}
+		// base64("ResolvedRefreshToken")
+		w.Write([]byte(`{"payload":{"data":"UmVzb2x2ZWRSZWZyZXNoVG9rZW4="}}`))
+	}))
+	defer srv.Close()
+
+	old := gsmAPIHost
+	gsmAPIHost = srv.URL
+	defer func() { gsmAPIHost = old }()
+
+	r := &GSMResolver{AccessToken: "GoodAccessToken"}
+	got, err := r.Resolve(context.Background(), "gsm://projects/p/secrets/google-ads-refresh/versions/latest")
+	if err != nil {
+		t.Fatalf("Resolve() error: %s", err)
+	}
+	if got != "ResolvedRefreshToken" {
+		t.Errorf("Resolve(): got %q, want %q", got, "ResolvedRefreshToken")
+	}
+}
+
+func TestAgeResolver(t *testing.T) {
+	r := &AgeResolver{
+		Identity: "/home/user/.config/age/keys.txt",
+		decrypt: func(ctx context.Context, identity, path string) ([]byte, error) {
+			if identity != "/home/user/.config/age/keys.txt" {
+				t.Errorf("decrypt identity: got %q, want %q", identity, "/home/user/.config/age/keys.txt")
+			}
+			if path != "/path/to/keys.age" {
+				t.Errorf("decrypt path: got %q, want %q", path, "/path/to/keys.age")
+			}
+			return []byte("developer_token: GoodDevToken\nrefresh_token: ResolvedRefreshToken\n"), nil
+		},
+	}
+
+	got, err := r.Resolve(context.Background(), "age:///path/to/keys.age#refresh_token")
+	if err != nil {
+		t.Fatalf("Resolve() error: %s", err)
+	}
+	if got != "ResolvedRefreshToken" {
+		t.Errorf("Resolve(): got %q, want %q", got, "ResolvedRefreshToken")
+	}
+}
+
+func TestAgeResolverMissingField(t *testing.T) {
+	r := &AgeResolver{
+		decrypt: func(ctx context.Context, identity, path string) ([]byte, error) {
+			return []byte("developer_token: GoodDevToken\n"), nil
+		},
+	}
+
+	_, err := r.Resolve(context.Background(), "age:///path/to/keys.age#refresh_token")
+	if err == nil || !strings.Contains(err.Error(), "no field") {
+		t.Errorf("Resolve() error: got %v, want a missing-field error", err)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 Google LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// VaultResolver resolves vault://<mount>/<path>#<field> references against a
+// HashiCorp Vault KV v2 secrets engine, talking to Vault's HTTP API directly
+// rather than depending on the Vault Go client.
+type VaultResolver struct {
+	// Addr is Vault's base URL, e.g. https://vault.example.com:8200.
+	Addr string
+	// Token authenticates the request.
+	Token string
+}
+
+// NewVaultResolver builds a VaultResolver from the VAULT_ADDR and
+// VAULT_TOKEN environment variables, the same ones the Vault CLI reads.
+func NewVaultResolver() *VaultResolver {
+	return &VaultResolver{
+		Addr:  os.Getenv("VAULT_ADDR"),
+		Token: os.Getenv("VAULT_TOKEN"),
+	}
+}
+
+// vaultKV2Response is the subset of a Vault KV v2 read response the
+// resolver cares about.
+type vaultKV2Response struct {
+	Data struct {
+		Data map[string]string `json:"data"`
+	} `json:"data"`
+}
+
+// Resolve reads the secret named by ref from Vault and returns the value of
+// its fragment field.
+func (r *VaultResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("parsing vault reference %q: %s", ref, err)
+	}
+	if r.Addr == "" {
+		return "", fmt.Errorf("resolving %q: VAULT_ADDR is not set", ref)
+	}
+	if u.Fragment == "" {
+		return "", fmt.Errorf("resolving %q: missing #field naming which key to read out of the secret", ref)
+	}
+
+	endpoint := strings.TrimRight(r.Addr, "/") + "/v1/" + u.Host + u.Path
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("building Vault request for %q: %s", ref, err)
+	}
+	req.Header.Set("X-Vault-Token", r.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("contacting Vault for %q: %s", ref, err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Vault returned %s for %q: %s", resp.Status, ref, body)
+	}
+
+	var parsed vaultKV2Response
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return "", fmt.Errorf("parsing Vault response for %q: %s", ref, err)
+	}
+
+	val, ok := parsed.Data.Data[u.Fragment]
+	if !ok {
+		return "", fmt.Errorf("secret %q has no field %q", ref, u.Fragment)
+	}
+	return val, nil
+}